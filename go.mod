@@ -3,14 +3,15 @@ module github.com/guihai/ghmqtt
 go 1.17
 
 require (
+	github.com/BurntSushi/toml v1.1.0
+	github.com/gorilla/websocket v1.5.0
 	go.uber.org/zap v1.21.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
-	github.com/BurntSushi/toml v1.1.0 // indirect
 	github.com/stretchr/testify v1.7.1 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -1,14 +1,28 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"github.com/guihai/ghmqtt/mqtt311/demo/router"
-	"github.com/guihai/ghmqtt/mqtt311/proto"
 	"github.com/guihai/ghmqtt/mqtt311/server"
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+	"github.com/guihai/ghmqtt/utils"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 
-	GHmqtt := server.NewGHapi()
+	// 3.1.1 和 5.0 使用同一个服务，路由和协议使用 mqtt5
+
+	// 配置文件 -config 或者环境变量 GHMQTT_CONFIG
+	configPath := flag.String("config", "", "配置文件路径 json, toml, yaml")
+	flag.Parse()
+
+	// 配置错误在 Run 时返回，收到 SIGHUP 重新读取配置文件
+	GHmqtt := server.NewGHapi(server.WithConfigFile(utils.ConfigPath(*configPath)))
 
 	// 注册链接验证
 	GHmqtt.SetConnectVerify(router.CheckConn)
@@ -16,7 +30,16 @@ func main() {
 	// 注册路由
 	addRouter(GHmqtt)
 
-	GHmqtt.Run()
+	// 收到退出信号后关闭服务
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	GHmqtt.ReloadOnSignal(ctx)
+
+	err := GHmqtt.Run(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 }
 
@@ -33,6 +56,7 @@ func addRouter(gh *server.GHapi) {
 	gh.AddRouter(proto.PUBLISH32, &router.PUBLISHRouter{})
 	gh.AddRouter(proto.PUBLISH33, &router.PUBLISHRouter{})
 	gh.AddRouter(proto.PUBLISH34, &router.PUBLISHRouter{})
+	gh.AddRouter(proto.PUBLISH35, &router.PUBLISHRouter{})
 	gh.AddRouter(proto.PUBACK, &router.PUBACKRouter{})
 	gh.AddRouter(proto.PUBREL, &router.PUBRELRouter{})
 	gh.AddRouter(proto.PUBREC, &router.PUBRECRouter{})
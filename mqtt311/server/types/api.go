@@ -1,14 +1,23 @@
 package types
 
-// 发布消息请求
-type PublishMsg struct {
-
-	// 主题
-	TopicName string
-	// 内容
-	TopicMsg string
-	// 保留标识
-	Retain bool
-	// Qos级别
-	Qos uint8
-}
+import (
+	types5 "github.com/guihai/ghmqtt/mqtt5/server/types"
+)
+
+/*
+接口类型和 mqtt5/server/types 相同
+*/
+
+type (
+	Response     = types5.Response
+	PageData     = types5.PageData
+	PageReq      = types5.PageReq
+	PublishMsg   = types5.PublishMsg
+	ConnItem     = types5.ConnItem
+	ReloadResult = types5.ReloadResult
+)
+
+var (
+	NewResponse = types5.NewResponse
+	NewPageData = types5.NewPageData
+)
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/guihai/ghmqtt/mqtt5/proto"
 	"net"
+	"strconv"
 )
 
 type Client struct {
@@ -47,7 +48,7 @@ func NewClient(ip string, port uint16, clientid, user, psd string) *Client {
 }
 
 func (s *Client) Run() {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", s.ip, s.port))
+	conn, err := net.Dial("tcp", net.JoinHostPort(s.ip, strconv.Itoa(int(s.port))))
 
 	if err != nil {
 		fmt.Println("连接服务器失败", err)
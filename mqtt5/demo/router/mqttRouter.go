@@ -1,13 +1,13 @@
 package router
 
 import (
-	"fmt"
 	"github.com/guihai/ghmqtt/mqtt5/proto"
 	"github.com/guihai/ghmqtt/mqtt5/server"
+	"github.com/guihai/ghmqtt/utils/ghlog"
 )
 
 // 实现链接验证方法
-func CheckConn(protocol *proto.CONNECTProtocol) uint8 {
+func CheckConn(protocol *proto.CONNECTProtocol, info *server.ConnInfo) uint8 {
 	//fmt.Println("这是CONNECTProtocolRouter  CheckConn")
 
 	if protocol.UserName == "" {
@@ -69,23 +69,14 @@ func (s *SUBSCRIBERouter) Handle(request *server.Request) {
 	// todo 架构实现协议，业务只返回订阅成功标识
 	sp := request.GetProto().(*proto.SUBSCRIBEProtocol)
 
-	// 订阅主题
-	for _, filter := range sp.TopicFilterList {
-		// 订阅主题
-		request.SubTopic(filter.FilterName)
+	// 订阅主题，返回授予的 QoS
+	codes := make([]byte, len(sp.TopicFilterList))
+	for i, filter := range sp.TopicFilterList {
+		// 订阅选项低 2 位是 QoS
+		codes[i] = request.SubTopicQos(filter.FilterName, filter.Options&0x03)
 	}
 
-	p := &proto.SUBACKProtocol{
-		Fixed: &proto.Fixed{
-			HeaderFlag: proto.SUBACK,
-			MsgLen:     uint32(2 + len(sp.TopicFilterList)), // 标识符 2 字节，返回code len(sp.TopicFilterList)
-			Data:       nil,
-		},
-		// 和订阅协议相同
-		PacketIdentifier: sp.PacketIdentifier,
-		// 全部返回订阅成功
-		ReturnCodeList: make([]byte, len(sp.TopicFilterList)),
-	}
+	p := proto.NewSUBACKProtocol(uint32(len(sp.TopicFilterList)), sp.PacketIdentifier, codes)
 
 	request.SendRES(p)
 }
@@ -136,21 +127,12 @@ func (s *PUBLISHRouter) Handle(request *server.Request) {
 		retain 保留信息 两种情况处理
 	*/
 
-	// 发布的数据 进入 主题管理器
-	// 版本1 直接开 主题接收发送协程
-	//go request.MsgIn(sp.TopicName, sp.Payload)
-	// 版本2 进入协程池
-	go request.MsgInPool(sp)
-
-	// 保留信息处理 暂定协程
-	if sp.Retain {
-		go request.SetRetainMsg(sp.TopicName, sp.Payload)
-	}
-
 	// 	Qos 处理
 	switch sp.Qos {
 	case proto.QoS1:
 
+		publish(request, sp)
+
 		// 返回 puback
 		p := &proto.PUBACKProtocol{
 			Fixed: &proto.Fixed{
@@ -167,21 +149,15 @@ func (s *PUBLISHRouter) Handle(request *server.Request) {
 
 	case proto.QoS2:
 		/*
-				1，验证标识符是否存在，存在的有其他信息在使用，不能存储
-			2，存储标识符
+				1，保存消息，收到 PUBREL 后再转发
+			2，标识符已经存在是重发的消息，只返回 PUBREC
 			3，返回 PUBRECProtocol 协议
 		*/
 
-		if request.GetQos2ID(sp.MsgId) {
-			// 存在返回真
-			// 重复的标识符，不能加入map 要关闭链接
-			fmt.Println("重复的标识符，不能加入map 要关闭链接", request.GetConnClientID())
-
-			request.ConnStop()
+		if !request.StoreQos2Msg(sp) {
+			request.Logger().Debug("【重发的 QoS2 消息】", ghlog.Uint16("id", sp.MsgId))
 		}
 
-		request.SetQos2ID(sp.MsgId)
-
 		p := &proto.PUBRECProtocol{
 			Fixed: &proto.Fixed{
 				HeaderFlag: proto.PUBREC,
@@ -195,11 +171,22 @@ func (s *PUBLISHRouter) Handle(request *server.Request) {
 
 	default:
 		// 默认无响应
-
+		publish(request, sp)
 	}
 
 }
 
+// 发布的数据 进入 主题管理器，有保留标志时保存保留信息
+func publish(request *server.Request, sp *proto.PUBLISHProtocol) {
+	// 版本2 进入协程池
+	go request.MsgInPool(sp)
+
+	// 保留信息处理 暂定协程
+	if sp.Retain {
+		go request.SetRetainMsgQos(sp.TopicName, sp.Payload, sp.Qos, sp.MessageExpiryInterval)
+	}
+}
+
 //默认 发布消息响应
 type PUBACKRouter struct {
 	*server.BaseRouter
@@ -211,7 +198,7 @@ type PUBACKRouter struct {
 func (s *PUBACKRouter) Handle(request *server.Request) {
 	sp := request.GetProto().(*proto.PUBACKProtocol)
 
-	fmt.Println("puback msg_id === ", sp.MsgId)
+	request.Logger().Debug("【收到确认】", ghlog.Uint16("id", sp.MsgId))
 }
 
 //默认 释放消息协议
@@ -220,26 +207,22 @@ type PUBRELRouter struct {
 }
 
 /*
-1， 移出 QosID
-2，返回响应
+1， 取出保存的 QoS2 消息并转发
+2，返回响应，标识符不存在返回 Packet_Inotf
 */
 func (s *PUBRELRouter) Handle(request *server.Request) {
 	sp := request.GetProto().(*proto.PUBRELProtocol)
 
-	// 移出 标识id
-	request.RemoveQos2ID(sp.MsgId)
+	code := proto.Success
+	msg, ok := request.ReleaseQos2Msg(sp.MsgId)
+	if !ok {
+		code = proto.Packet_Inotf
+	} else if msg != nil {
+		publish(request, msg)
+	}
 
 	// 需要返回PUBCOMP
-
-	p := &proto.PUBCOMPProtocol{
-		Fixed: &proto.Fixed{
-			HeaderFlag: proto.PUBCOMP,
-			MsgLen:     2,
-			Data:       nil,
-		},
-		PacketIdentifier: sp.PacketIdentifier,
-		MsgId:            sp.MsgId,
-	}
+	p := proto.NewPUBCOMPProtocol(sp.PacketIdentifier, code)
 
 	request.SendRES(p)
 }
@@ -253,21 +236,33 @@ type PUBRECRouter struct {
 业务方可以解析 标识符合发布的标识符对应
 
 返回  PUBRELProtocol
+原因码大于等于 0x80 时消息已经完成，不返回
+报文标识符不是发送中的消息时返回 Packet_Inotf
 */
 func (s *PUBRECRouter) Handle(request *server.Request) {
 	sp := request.GetProto().(*proto.PUBRECProtocol)
 
-	fmt.Println("PUBREC msg_id === ", sp.MsgId)
+	request.Logger().Debug("【收到确认】", ghlog.Uint16("id", sp.MsgId))
+
+	if sp.ReasonCode >= 0x80 {
+		return
+	}
+
+	code := proto.Success
+	if !request.InflightFound() {
+		code = proto.Packet_Inotf
+	}
 
 	// 需要返回 PUBRELProtocol
 	p := &proto.PUBRELProtocol{
 		Fixed: &proto.Fixed{
 			HeaderFlag: proto.PUBREL,
-			MsgLen:     2,
+			MsgLen:     4, // 标识符 2 + 原因码 1 + 属性 1
 			Data:       nil,
 		},
 		PacketIdentifier: sp.PacketIdentifier,
 		MsgId:            sp.MsgId,
+		ReasonCode:       code,
 	}
 
 	request.SendRES(p)
@@ -284,5 +279,5 @@ type PUBCOMPRouter struct {
 func (s *PUBCOMPRouter) Handle(request *server.Request) {
 	sp := request.GetProto().(*proto.PUBCOMPProtocol)
 
-	fmt.Println("PUBCOMP msg_id === ", sp.MsgId)
+	request.Logger().Debug("【收到确认】", ghlog.Uint16("id", sp.MsgId))
 }
@@ -123,14 +123,61 @@ func NewCONNACKProtocolF(f *Fixed) *CONNACKProtocol {
 //
 //}
 func (s *CONNACKProtocol) Pack() ([]byte, error) {
+
+	// 属性 值为 0 或者空的不发送
+	// todo MaximumQoS, RetainAvailable 等字节属性 0 有意义，暂时不发送
+	var props []byte
+	if s.SessionExpiryInterval > 0 {
+		props = append(props, s.packPropUint32(SessionEI, s.SessionExpiryInterval)...)
+	}
+	if s.ReceiveMaximum > 0 {
+		props = append(props, s.packPropUint16(ReceiveMaximum, s.ReceiveMaximum)...)
+	}
+	if s.MaximumPacketSize > 0 {
+		props = append(props, s.packPropUint32(MaximumPS, s.MaximumPacketSize)...)
+	}
+	if s.AssignedClientIdentifier != "" {
+		props = append(props, s.packPropString(AssignedCI, s.AssignedClientIdentifier)...)
+	}
+	if s.TopicAliasMaximum > 0 {
+		props = append(props, s.packPropUint16(TopicAM, s.TopicAliasMaximum)...)
+	}
+	if s.ReasonString != "" {
+		props = append(props, s.packPropString(ReasonString, s.ReasonString)...)
+	}
+	props = append(props, s.packPropUser(s.UserProperty)...)
+	if s.ServerKeepAlive > 0 {
+		props = append(props, s.packPropUint16(ServerKA, s.ServerKeepAlive)...)
+	}
+	if s.ResponseInformation != "" {
+		props = append(props, s.packPropString(ResponseI, s.ResponseInformation)...)
+	}
+	if s.ServerReference != "" {
+		props = append(props, s.packPropString(ServerRef, s.ServerReference)...)
+	}
+	if s.AuthenticationMethod != "" {
+		props = append(props, s.packPropString(AuthenticationM, s.AuthenticationMethod)...)
+	}
+	if s.AuthenticationData != "" {
+		props = append(props, s.packPropString(AuthenticationD, s.AuthenticationData)...)
+	}
+
+	s.PropertiesLength = uint32(len(props))
+
+	// 可变报头 确认标志 + 返回码 + 属性长度 + 属性
+	plen := s.msgLenCode(s.PropertiesLength)
+	s.MsgLen = 2 + uint32(len(plen)) + s.PropertiesLength
+
 	// 固定报头
-	by := make([]byte, 1, 4) // 至少4个字节
+	by := make([]byte, 1, 5+s.MsgLen) // 至少5个字节
 	by[0] = s.GetHeaderFlag()
 
 	by = append(by, s.msgLenCode(s.GetMsgLen())...)
 
 	// 可变报头
 	by = append(by, s.ConnectAcknowledgeFlags, s.ConnectReturncode)
+	by = append(by, plen...)
+	by = append(by, props...)
 
 	return by, nil
 
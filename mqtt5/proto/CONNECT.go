@@ -168,8 +168,8 @@ func (s *CONNECTProtocol) Pack() ([]byte, error) {
 
 func (s *CONNECTProtocol) UnPack() error {
 
-	// 检测f 的基本长度  10(基础) + 1（属性长度1） +  3(至少有 clientid)
-	if s.Fixed.MsgLen < 14 {
+	// 检测f 的基本长度  10(基础) + 1（属性长度1） +  2(clientid 长度，可以为 0)
+	if s.Fixed.MsgLen < 13 {
 		s.AckCode = Malformed_Packet
 		// "数据长度错误"
 		return errors.New("数据长度错误")
@@ -317,16 +317,17 @@ func (s *CONNECTProtocol) UnPack() error {
 	// 拆解 有效载荷
 	daByp := daBy[indx:] // 获取有效载荷的后续数据
 
-	if len(daByp) < 3 {
+	if len(daByp) < 2 {
 		s.AckCode = ClientInotv
-		return errors.New("第一次链接必须有 client 必须有值")
+		return errors.New("第一次链接必须有 client 长度")
 	}
 
+	// client 长度为 0 时由服务端分配
 	s.ClientIDLength, s.ClientID, _ = s.by2LenNameBE(daByp)
 
-	if s.ClientIDLength < 1 || s.ClientID == "" {
+	if s.ClientIDLength > 0 && s.ClientID == "" {
 		s.AckCode = ClientInotv
-		return errors.New("第一次链接必须有 client 必须有值")
+		return errors.New("client 长度错误")
 	}
 
 	daByp2 := daByp[2+s.ClientIDLength:] // 获取后续数据
@@ -431,9 +432,9 @@ func (s *CONNECTProtocol) UnPack() error {
 				}
 
 			}
-			// 截取数据
-			daByp2 = daByp2[idx+s.WillProperties:]
 		}
+		// 截取数据，没有遗嘱属性时跳过属性长度
+		daByp2 = daByp2[idx+s.WillProperties:]
 
 		s.WillTopicLength, s.WillTopic, _ = s.by2LenNameBE(daByp2)
 		if s.WillTopicLength < 1 || s.WillTopic == "" {
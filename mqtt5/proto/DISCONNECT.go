@@ -20,6 +20,7 @@ type DISCONNECTProtocol struct {
 
 	PropertiesLength      uint32            // 1-4 字节 和 msgLen 相同
 	SessionExpiryInterval uint32            // 会话过期间隔	四字节整数
+	SessionExpirySet      bool              // 是否有会话过期间隔属性，没有时使用 CONNECT 中的值
 	ReasonString          string            // 原因字符串	UTF-8编码字符串
 	UserProperty          map[string]string // 用户属性	字符串
 	ServerReference       string            // 服务端参考	UTF-8编码字符串
@@ -37,6 +38,55 @@ func NewDISCONNECTProtocol(f *Fixed) *DISCONNECTProtocol {
 	}
 }
 
+/*
+服务端断开链接 S=>C
+*/
+func NewDISCONNECTProtocolCode(code uint8) *DISCONNECTProtocol {
+	p := NewDISCONNECTProtocol(&Fixed{
+		HeaderFlag: DISCONNECT,
+		MsgLen:     0,
+		Data:       nil,
+	})
+	p.ReasonCode = code
+
+	return p
+}
+
+func (s *DISCONNECTProtocol) Pack() ([]byte, error) {
+
+	// 属性
+	var props []byte
+	if s.SessionExpiryInterval > 0 {
+		props = append(props, s.packPropUint32(SessionEI, s.SessionExpiryInterval)...)
+	}
+	if s.ReasonString != "" {
+		props = append(props, s.packPropString(ReasonString, s.ReasonString)...)
+	}
+	if s.ServerReference != "" {
+		props = append(props, s.packPropString(ServerRef, s.ServerReference)...)
+	}
+	props = append(props, s.packPropUser(s.UserProperty)...)
+
+	s.PropertiesLength = uint32(len(props))
+
+	// 可变报头 原因码 + 属性长度 + 属性
+	body := make([]byte, 0, 1+4+len(props))
+	body = append(body, s.ReasonCode)
+	body = append(body, s.msgLenCode(s.PropertiesLength)...)
+	body = append(body, props...)
+
+	s.MsgLen = uint32(len(body))
+
+	// 固定报头
+	by := make([]byte, 1, 2+len(body))
+	by[0] = s.GetHeaderFlag()
+
+	by = append(by, s.msgLenCode(s.GetMsgLen())...)
+	by = append(by, body...)
+
+	return by, nil
+}
+
 func (s *DISCONNECTProtocol) UnPack() error {
 
 	if s.Fixed.MsgLen < 1 {
@@ -67,6 +117,7 @@ func (s *DISCONNECTProtocol) UnPack() error {
 				// 四字节整数
 				binary.Read(bytes.NewBuffer(temp[tinx+1:tinx+1+4]),
 					binary.BigEndian, &s.SessionExpiryInterval)
+				s.SessionExpirySet = true
 				tinx = tinx + 1 + 4
 
 			case ServerRef:
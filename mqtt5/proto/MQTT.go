@@ -14,6 +14,8 @@ const (
 
 	PUBLISH34 = 0x34 // == 52   0011 0100   Qos2 需要 PUBREC    C<=>S
 
+	PUBLISH35 = 0x35 // == 53   0011 0101   Qos2 需要 PUBREC 保留信息   C<=>S
+
 	PUBLISHMAX = 0x3D // == 61   订阅协议二进制转化 ，最大值
 
 	PUBACK      = 0x40 // == 64   0100 0000         C<=>S
@@ -119,3 +121,16 @@ const (
 	SubscriptionIA  uint8 = 0x29 //Subscription Identifier Available 订阅标识符可用性	字节	CONNACK
 	SharedSA        uint8 = 0x2A //Shared Subscription Available 共享订阅可用性	字节	CONNACK
 )
+
+// 报文类型名称，固定报头高 4 位
+var packetNames = [16]string{
+	"RESERVED", "CONNECT", "CONNACK", "PUBLISH", "PUBACK", "PUBREC", "PUBREL", "PUBCOMP",
+	"SUBSCRIBE", "SUBACK", "UNSUBSCRIBE", "UNSUBACK", "PINGREQ", "PINGRESP", "DISCONNECT", "AUTH",
+}
+
+/*
+根据固定报头获取报文类型名称，用于日志
+*/
+func PacketName(flag uint8) string {
+	return packetNames[flag>>4]
+}
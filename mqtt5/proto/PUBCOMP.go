@@ -78,14 +78,19 @@ func (s *PUBCOMPProtocol) Pack() ([]byte, error) {
 }
 
 func (s *PUBCOMPProtocol) UnPack() error {
-	// 剩余长度至少 3
-	if s.Fixed.MsgLen < 3 {
+	// 剩余长度至少 2，等于 2 时没有原因码，表示成功
+	if s.Fixed.MsgLen < 2 {
 		s.AckCode = Malformed_Packet
 		return errors.New("报文长度错误")
 	}
 	binary.Read(bytes.NewBuffer(s.PacketIdentifier[:]),
 		binary.BigEndian, &s.MsgId)
 
+	if s.Fixed.MsgLen == 2 {
+		s.ReasonCode = Success
+		return nil
+	}
+
 	s.ReasonCode = s.Fixed.Data[2]
 
 	// 属性长度
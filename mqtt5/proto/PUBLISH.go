@@ -42,6 +42,9 @@ type PUBLISHProtocol struct {
 	// 解析 Retain 用于处理
 	Retain bool // 0 或者1
 
+	// 解析 DUP 重发标志
+	Dup bool
+
 	// AckCode 生成对应响应使用的AckCode
 	AckCode uint8
 }
@@ -81,148 +84,230 @@ func (s *PUBLISHProtocol) UnPack() error {
 		return errors.New("报文长度错误")
 	}
 
+	/*
+		固定报头低 4 位
+		第 3 位 DUP 重发标志
+		第 1，2 位 QoS，不能是 3
+		第 0 位 Retain 保留信息
+	*/
+	s.Dup = s.Fixed.HeaderFlag&0x08 != 0
+	s.Qos = (s.Fixed.HeaderFlag >> 1) & 0x03
+	s.Retain = s.Fixed.HeaderFlag&0x01 != 0
+
+	if s.Qos > QoS2 {
+		s.AckCode = Malformed_Packet
+		return errors.New("QoS 错误")
+	}
+
 	binary.Read(bytes.NewBuffer(s.Fixed.Data[:2]),
 		binary.BigEndian, &s.TopicNameLength)
 
-	s.TopicName = string(s.Fixed.Data[2:(2 + s.TopicNameLength)])
-
-	switch s.Fixed.HeaderFlag {
-	case PUBLISH:
-		// 48 没有 PacketIdentifier
-		// 获取属性
-		by := s.Fixed.Data[(2 + s.TopicNameLength):]
-		s.PropertiesLength, by = s.unPackPropertyLength(by)
-
-		if s.PropertiesLength > 0 {
-			// 获取属性
-			by = by[s.PropertiesLength:]
-		}
-
-		s.Payload = by
-		s.Qos = QoS0
-
-	case PUBLISH31:
-		// 49 没有 PacketIdentifier 有保留位
-		by := s.Fixed.Data[(2 + s.TopicNameLength):]
-		// 获取属性
-		s.PropertiesLength, by = s.unPackPropertyLength(by)
-
-		if s.PropertiesLength > 0 {
-			// 获取属性
-			by = by[s.PropertiesLength:]
-		}
+	// 主题 + QoS1，2 的报文标识符 + 至少 1 个字节属性长度
+	n := 2 + int(s.TopicNameLength)
+	if s.Qos > QoS0 {
+		n += 2
+	}
+	if len(s.Fixed.Data) < n+1 {
+		s.AckCode = Malformed_Packet
+		return errors.New("报文长度错误")
+	}
 
-		s.Payload = by
-		s.Qos = QoS0
-		s.Retain = true
+	s.TopicName = string(s.Fixed.Data[2:(2 + s.TopicNameLength)])
 
-	case PUBLISH32:
-		// 50
+	if s.Qos > QoS0 {
+		// Qos1,2 都要有标识符
 		s.PacketIdentifier = [2]byte{s.Fixed.Data[2+s.TopicNameLength],
 			s.Fixed.Data[2+s.TopicNameLength+1]}
 		// 计算标识符id  大端编码 字节写入数字
 		binary.Read(bytes.NewBuffer(s.PacketIdentifier[:]),
 			binary.BigEndian, &s.MsgId)
-		// 获取属性
-		by := s.Fixed.Data[(2 + s.TopicNameLength + 2):]
-		s.PropertiesLength, by = s.unPackPropertyLength(by)
-
-		if s.PropertiesLength > 0 {
-			// 获取属性
-			by = by[s.PropertiesLength:]
-		}
+	}
 
-		s.Payload = by
+	// 获取属性
+	by := s.Fixed.Data[n:]
+	s.PropertiesLength, by = s.unPackPropertyLength(by)
 
-		//p.Payload = f.Data[(2 + p.TopicNameLength + 1 + 1):]
-		s.Qos = QoS1
+	if uint32(len(by)) < s.PropertiesLength {
+		s.AckCode = Malformed_Packet
+		return errors.New("属性长度错误")
+	}
 
-	case PUBLISH33:
-		// 51
-		s.PacketIdentifier = [2]byte{s.Fixed.Data[2+s.TopicNameLength],
-			s.Fixed.Data[2+s.TopicNameLength+1]}
-		// 计算标识符id  大端编码 字节写入数字
-		binary.Read(bytes.NewBuffer(s.PacketIdentifier[:]),
-			binary.BigEndian, &s.MsgId)
+	if s.PropertiesLength > 0 {
 		// 获取属性
-		by := s.Fixed.Data[(2 + s.TopicNameLength + 2):]
-		s.PropertiesLength, by = s.unPackPropertyLength(by)
-
-		if s.PropertiesLength > 0 {
-			// 获取属性
-			by = by[s.PropertiesLength:]
+		if err := s.unPackProperties(by[:s.PropertiesLength]); err != nil {
+			s.AckCode = Malformed_Packet
+			return err
 		}
+		by = by[s.PropertiesLength:]
+	}
 
-		s.Payload = by
+	s.Payload = by
 
-		s.Qos = QoS1
-		s.Retain = true
+	return nil
+}
 
-	case PUBLISH34:
-		// 52
-		s.PacketIdentifier = [2]byte{s.Fixed.Data[2+s.TopicNameLength],
-			s.Fixed.Data[2+s.TopicNameLength+1]}
-		// 计算标识符id  大端编码 字节写入数字
-		binary.Read(bytes.NewBuffer(s.PacketIdentifier[:]),
-			binary.BigEndian, &s.MsgId)
+/*
+解析属性，长度不够时返回错误
+*/
+func (s *PUBLISHProtocol) unPackProperties(by []byte) error {
+	errLen := errors.New("属性长度错误")
 
-		// 获取属性
-		by := s.Fixed.Data[(2 + s.TopicNameLength + 2):]
-		s.PropertiesLength, by = s.unPackPropertyLength(by)
+	// 读取 UTF-8 编码字符串或者二进制数据  两个字节长度 + 内容
+	readString := func(i int) (string, int, bool) {
+		if len(by) < i+2 {
+			return "", i, false
+		}
+		n := int(binary.BigEndian.Uint16(by[i:]))
+		if len(by) < i+2+n {
+			return "", i, false
+		}
+		return string(by[i+2 : i+2+n]), i + 2 + n, true
+	}
 
-		if s.PropertiesLength > 0 {
-			// 获取属性
-			by = by[s.PropertiesLength:]
+	i := 0
+	for i < len(by) {
+		id := by[i]
+		i++
+
+		var ok bool
+		switch id {
+		case PayloadFI:
+			if len(by) < i+1 {
+				return errLen
+			}
+			s.PayloadFormatIndicator = by[i]
+			i++
+
+		case MessageEI:
+			if len(by) < i+4 {
+				return errLen
+			}
+			s.MessageExpiryInterval = binary.BigEndian.Uint32(by[i:])
+			i += 4
+
+		case TopicAlias:
+			if len(by) < i+2 {
+				return errLen
+			}
+			s.TopicAlias = binary.BigEndian.Uint16(by[i:])
+			i += 2
+
+		case ResponseTopic:
+			if s.ResponseTopic, i, ok = readString(i); !ok {
+				return errLen
+			}
+
+		case CorrelationData:
+			if s.CorrelationData, i, ok = readString(i); !ok {
+				return errLen
+			}
+
+		case ContentType:
+			if s.ContentType, i, ok = readString(i); !ok {
+				return errLen
+			}
+
+		case UserProperty:
+			var key, val string
+			if key, i, ok = readString(i); !ok {
+				return errLen
+			}
+			if val, i, ok = readString(i); !ok {
+				return errLen
+			}
+			if s.UserProperty == nil {
+				s.UserProperty = make(map[string]string)
+			}
+			s.UserProperty[key] = val
+
+		case SubscriptionI:
+			// 变长字节整数
+			var n, multiplier uint32 = 0, 1
+			for {
+				if len(by) <= i || multiplier > 128*128*128 {
+					return errLen
+				}
+				b := by[i]
+				i++
+				n += uint32(b&0x7F) * multiplier
+				if b < 0x80 {
+					break
+				}
+				multiplier *= 128
+			}
+			s.SubscriptionIdentifier = n
+
+		default:
+			return errors.New("属性标识符错误")
 		}
-		s.Payload = by
-		s.Qos = QoS2
+	}
 
-	default:
-		// 其他 Qos1,2 都要有标识符
-		s.PacketIdentifier = [2]byte{s.Fixed.Data[2+s.TopicNameLength],
-			s.Fixed.Data[2+s.TopicNameLength+1]}
-		// 计算标识符id  大端编码 字节写入数字
-		binary.Read(bytes.NewBuffer(s.PacketIdentifier[:]),
-			binary.BigEndian, &s.MsgId)
-		// 获取属性
-		by := s.Fixed.Data[(2 + s.TopicNameLength + 2):]
-		s.PropertiesLength, by = s.unPackPropertyLength(by)
+	return nil
+}
 
-		if s.PropertiesLength > 0 {
-			// 获取属性
-			by = by[s.PropertiesLength:]
-		}
+/*
+属性打包，没有设置的属性不打包
+*/
+func (s *PUBLISHProtocol) packProperties() []byte {
+	var props []byte
 
-		s.Payload = by
-		// todo 暂定1
-		s.Qos = QoS1
+	if s.PayloadFormatIndicator > 0 {
+		props = append(props, s.packPropByte(PayloadFI, s.PayloadFormatIndicator)...)
+	}
+	if s.MessageExpiryInterval > 0 {
+		props = append(props, s.packPropUint32(MessageEI, s.MessageExpiryInterval)...)
+	}
+	if s.TopicAlias > 0 {
+		props = append(props, s.packPropUint16(TopicAlias, s.TopicAlias)...)
+	}
+	if s.ResponseTopic != "" {
+		props = append(props, s.packPropString(ResponseTopic, s.ResponseTopic)...)
+	}
+	if s.CorrelationData != "" {
+		props = append(props, s.packPropString(CorrelationData, s.CorrelationData)...)
+	}
+	props = append(props, s.packPropUser(s.UserProperty)...)
+	if s.SubscriptionIdentifier > 0 {
+		props = append(props, SubscriptionI)
+		props = append(props, s.msgLenCode(s.SubscriptionIdentifier)...)
+	}
+	if s.ContentType != "" {
+		props = append(props, s.packPropString(ContentType, s.ContentType)...)
 	}
 
-	return nil
+	return props
 }
 
 func (s *PUBLISHProtocol) Pack() ([]byte, error) {
 
-	// 固定报头
-	by := make([]byte, 1, 8) // 至少8个字节
-	by[0] = s.GetHeaderFlag()
-
-	by = append(by, s.msgLenCode(s.GetMsgLen())...)
+	// 属性
+	props := s.packProperties()
+	s.PropertiesLength = uint32(len(props))
 
-	// 可变报头
-	by = append(by, s.int16ToByBig(s.TopicNameLength)...)
-	by = append(by, []byte(s.TopicName)...)
+	// 可变报头 主题 + 标识符 + 属性长度 + 属性
+	body := make([]byte, 0, 2+len(s.TopicName)+2+4+len(props)+len(s.Payload))
+	body = append(body, s.int16ToByBig(s.TopicNameLength)...)
+	body = append(body, []byte(s.TopicName)...)
 
 	// 根据 报头 ，确定是否有 标识符
 	if s.Qos > QoS0 {
 		// 需要 获取标识符
-		by = append(by, s.int16ToByBig(s.MsgId)...)
+		body = append(body, s.int16ToByBig(s.MsgId)...)
 	}
 
-	// todo 属性默认0
-	by = append(by, s.msgLenCode(s.PropertiesLength)...)
+	body = append(body, s.msgLenCode(s.PropertiesLength)...)
+	body = append(body, props...)
 	// 有效载荷
-	by = append(by, s.Payload...)
+	body = append(body, s.Payload...)
+
+	s.MsgLen = uint32(len(body))
+
+	// 固定报头
+	by := make([]byte, 1, 5+len(body))
+	by[0] = s.GetHeaderFlag()
+
+	by = append(by, s.msgLenCode(s.GetMsgLen())...)
+	by = append(by, body...)
 
 	return by, nil
 
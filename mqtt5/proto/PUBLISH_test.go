@@ -0,0 +1,91 @@
+package proto
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPUBLISHUnPackProperties(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		in      []byte
+		want    *PUBLISHProtocol
+		wantErr bool
+	}{
+		{"载荷格式说明", []byte{PayloadFI, 1}, &PUBLISHProtocol{PayloadFormatIndicator: 1}, false},
+		{"消息过期间隔", []byte{MessageEI, 0, 0, 1, 0}, &PUBLISHProtocol{MessageExpiryInterval: 256}, false},
+		{"主题别名", []byte{TopicAlias, 0, 5}, &PUBLISHProtocol{TopicAlias: 5}, false},
+		{"字符串属性", []byte{ResponseTopic, 0, 1, 'r', CorrelationData, 0, 2, 0, 1, ContentType, 0, 0},
+			&PUBLISHProtocol{ResponseTopic: "r", CorrelationData: "\x00\x01"}, false},
+		{"用户属性", []byte{UserProperty, 0, 1, 'k', 0, 1, 'v', UserProperty, 0, 1, 'a', 0, 0},
+			&PUBLISHProtocol{UserProperty: map[string]string{"k": "v", "a": ""}}, false},
+		{"订阅标识符变长整数", []byte{SubscriptionI, 0x80, 0x01}, &PUBLISHProtocol{SubscriptionIdentifier: 128}, false},
+		{"多个属性", []byte{TopicAlias, 0, 1, PayloadFI, 0, MessageEI, 0, 0, 0, 9},
+			&PUBLISHProtocol{TopicAlias: 1, MessageExpiryInterval: 9}, false},
+		{"消息过期间隔截断", []byte{MessageEI, 0, 0, 1}, nil, true},
+		{"主题别名截断", []byte{TopicAlias, 0}, nil, true},
+		{"字符串长度超过属性", []byte{ResponseTopic, 0, 5, 'r'}, nil, true},
+		{"用户属性没有值", []byte{UserProperty, 0, 1, 'k'}, nil, true},
+		{"订阅标识符截断", []byte{SubscriptionI, 0x80}, nil, true},
+		{"订阅标识符超过 4 个字节", []byte{SubscriptionI, 0x80, 0x80, 0x80, 0x80, 0x01}, nil, true},
+		{"未知属性", []byte{0x7F, 0}, nil, true},
+	}
+
+	for _, c := range cases {
+		p := &PUBLISHProtocol{}
+		err := p.unPackProperties(c.in)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("%s: 错误 %v", c.name, err)
+		}
+		if !c.wantErr && !reflect.DeepEqual(p, c.want) {
+			t.Fatalf("%s: 想要 %+v 收到 %+v", c.name, c.want, p)
+		}
+	}
+}
+
+func TestPUBLISHPackUnPack(t *testing.T) {
+
+	in := &PUBLISHProtocol{
+		Fixed:                  &Fixed{HeaderFlag: PUBLISH33},
+		TopicNameLength:        3,
+		TopicName:              "a/b",
+		MsgId:                  10,
+		PayloadFormatIndicator: 1,
+		MessageExpiryInterval:  30,
+		TopicAlias:             2,
+		ResponseTopic:          "r/1",
+		CorrelationData:        "c",
+		UserProperty:           map[string]string{"k": "v"},
+		ContentType:            "text/plain",
+		Payload:                []byte("hello"),
+		Qos:                    QoS1,
+	}
+
+	by, err := in.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 固定头部 1 + 剩余长度 1
+	out := NewPUBLISHProtocol(&Fixed{HeaderFlag: by[0], MsgLen: uint32(by[1]), Data: by[2:]})
+	if err := out.UnPack(); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.TopicName != in.TopicName || out.MsgId != 10 || !out.Retain || out.Qos != QoS1 || string(out.Payload) != "hello" {
+		t.Fatalf("%+v", out)
+	}
+	if out.PayloadFormatIndicator != 1 || out.MessageExpiryInterval != 30 || out.TopicAlias != 2 || out.ResponseTopic != "r/1" ||
+		out.CorrelationData != "c" || out.ContentType != "text/plain" || !reflect.DeepEqual(out.UserProperty, in.UserProperty) {
+		t.Fatalf("属性 %+v", out)
+	}
+
+	// 属性长度超过报文
+	bad := append([]byte{}, by[2:2+2+3+2]...)
+	bad = append(bad, 10, PayloadFI)
+	p := NewPUBLISHProtocol(&Fixed{HeaderFlag: PUBLISH32, MsgLen: uint32(len(bad)), Data: bad})
+	if err := p.UnPack(); err == nil || p.AckCode != Malformed_Packet {
+		t.Fatalf("应该返回报文格式错误 %v", err)
+	}
+}
@@ -43,8 +43,8 @@ func NewPUBRELProtocol(f *Fixed) *PUBRELProtocol {
 }
 func (s *PUBRELProtocol) UnPack() error {
 
-	// 剩余长度至少 3
-	if s.Fixed.MsgLen < 3 {
+	// 剩余长度至少 2，等于 2 时没有原因码，表示成功
+	if s.Fixed.MsgLen < 2 {
 
 		s.AckCode = Malformed_Packet
 		return errors.New("报文长度错误")
@@ -53,6 +53,11 @@ func (s *PUBRELProtocol) UnPack() error {
 	binary.Read(bytes.NewBuffer(s.PacketIdentifier[:]),
 		binary.BigEndian, &s.MsgId)
 
+	if s.Fixed.MsgLen == 2 {
+		s.ReasonCode = Success
+		return nil
+	}
+
 	s.ReasonCode = s.Fixed.Data[2]
 
 	// 属性长度
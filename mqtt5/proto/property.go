@@ -0,0 +1,50 @@
+package proto
+
+import (
+	"encoding/binary"
+)
+
+/*
+属性打包 服务端发送的协议使用
+属性 = 标识符 + 值
+*/
+
+// 字节属性
+func (s *Fixed) packPropByte(id uint8, v uint8) []byte {
+	return []byte{id, v}
+}
+
+// 双字节整数属性
+func (s *Fixed) packPropUint16(id uint8, v uint16) []byte {
+	by := make([]byte, 3)
+	by[0] = id
+	binary.BigEndian.PutUint16(by[1:], v)
+	return by
+}
+
+// 四字节整数属性
+func (s *Fixed) packPropUint32(id uint8, v uint32) []byte {
+	by := make([]byte, 5)
+	by[0] = id
+	binary.BigEndian.PutUint32(by[1:], v)
+	return by
+}
+
+// UTF-8编码字符串属性  两个字节长度 + 字符串
+func (s *Fixed) packPropString(id uint8, v string) []byte {
+	by := make([]byte, 3, 3+len(v))
+	by[0] = id
+	binary.BigEndian.PutUint16(by[1:], uint16(len(v)))
+	return append(by, v...)
+}
+
+// 用户属性 字符串对
+func (s *Fixed) packPropUser(m map[string]string) []byte {
+	var by []byte
+	for k, v := range m {
+		by = append(by, s.packPropString(UserProperty, k)...)
+		// 值没有标识符
+		by = append(by, s.packPropString(UserProperty, v)[1:]...)
+	}
+	return by
+}
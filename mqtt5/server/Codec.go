@@ -0,0 +1,314 @@
+package server
+
+import (
+	"errors"
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+)
+
+/*
+协议编解码，每个链接根据 CONNECT 中的协议级别选择
+服务内部统一使用 v5 报文，路由，主题管理器都只处理 v5 协议
+1，v3.1.1 链接收到的报文补上属性长度，原因码，转换成 v5 报文后解析
+2，发送给 v3.1.1 链接的报文去掉属性，原因码转换成 v3.1.1 返回码
+3.1.1 和 5.0 的链接使用同一个主题空间，消息发给 v3.1.1 订阅者时丢弃 v5 属性
+*/
+
+// 协议级别
+const (
+	levelV311 uint8 = 0x04 // MQTT 3.1.1
+	levelV5   uint8 = 0x05 // MQTT 5.0
+)
+
+// v3.1.1 CONNACK 返回码
+const (
+	v311Accepted          uint8 = 0x00 // 链接已接受
+	v311RefusedVersion    uint8 = 0x01 // 不支持的协议版本
+	v311RefusedIdentifier uint8 = 0x02 // 客户标识符不合格
+	v311RefusedServer     uint8 = 0x03 // 服务端不可用
+	v311RefusedUserPSD    uint8 = 0x04 // 用户名密码错误
+	v311RefusedAuth       uint8 = 0x05 // 未授权
+)
+
+// v3.1.1 SUBACK 订阅失败
+const v311SubFailure uint8 = 0x80
+
+// 报文格式错误
+var errMalformed = errors.New("报文格式错误")
+
+type codec interface {
+	// 协议级别
+	level() uint8
+	// 收到的报文转换成 v5 报文
+	decode(f *proto.Fixed) (*proto.Fixed, error)
+	// v5 报文转换成本链接使用的报文，返回 nil 不发送
+	encode(by []byte) []byte
+}
+
+/*
+根据 CONNECT 选择编解码，不支持的协议级别返回 UnsupportedPV
+协议级别小于 5 的客户端使用 v3.1.1 返回 CONNACK
+*/
+func newCodec(f *proto.Fixed) (codec, uint8) {
+
+	data := f.Data
+	if len(data) < 2 {
+		return v5Codec{}, proto.Malformed_Packet
+	}
+
+	nameLen := int(data[0])<<8 | int(data[1])
+	if len(data) < 2+nameLen+1 {
+		return v5Codec{}, proto.Malformed_Packet
+	}
+
+	name := string(data[2 : 2+nameLen])
+	level := data[2+nameLen]
+
+	switch {
+	case name == "MQTT" && level == levelV5:
+		return v5Codec{}, proto.Success
+	case name == "MQTT" && level == levelV311:
+		return v311Codec{}, proto.Success
+	case level < levelV5:
+		return v311Codec{}, proto.UnsupportedPV
+	default:
+		return v5Codec{}, proto.UnsupportedPV
+	}
+}
+
+/*
+v5 不需要转换
+*/
+type v5Codec struct{}
+
+func (s v5Codec) level() uint8 {
+	return levelV5
+}
+
+func (s v5Codec) decode(f *proto.Fixed) (*proto.Fixed, error) {
+	return f, nil
+}
+
+func (s v5Codec) encode(by []byte) []byte {
+	return by
+}
+
+/*
+v3.1.1 和 v5 报文转换
+*/
+type v311Codec struct{}
+
+func (s v311Codec) level() uint8 {
+	return levelV311
+}
+
+/*
+v3.1.1 报文转换成 v5 报文
+在可变报头中插入属性长度 0，确认报文补上原因码 0
+*/
+func (s v311Codec) decode(f *proto.Fixed) (*proto.Fixed, error) {
+
+	data := f.Data
+	var out []byte
+
+	switch flag := f.HeaderFlag; {
+	case flag == proto.CONNECT:
+		// 协议名 6 + 级别 1 + 标志 1 + 保活 2
+		if len(data) < 12 {
+			return nil, errMalformed
+		}
+
+		// 解析时使用 v5 级别
+		head := append([]byte{}, data[:10]...)
+		head[6] = levelV5
+		out = append(head, 0)
+
+		// 有遗嘱时在客户标识符后面插入遗嘱属性长度 0
+		idLen := int(data[10])<<8 | int(data[11])
+		if len(data) < 12+idLen {
+			return nil, errMalformed
+		}
+		out = append(out, data[10:12+idLen]...)
+		if data[7]&0x04 != 0 {
+			out = append(out, 0)
+		}
+		out = append(out, data[12+idLen:]...)
+
+	case flag&0xF0 == proto.PUBLISH:
+		// 主题 + QoS1，2 的报文标识符
+		if len(data) < 2 {
+			return nil, errMalformed
+		}
+		n := 2 + (int(data[0])<<8 | int(data[1]))
+		if (flag>>1)&0x03 > 0 {
+			n += 2
+		}
+		if len(data) < n {
+			return nil, errMalformed
+		}
+		out = insertByte(data, n, 0)
+
+	case flag == proto.PUBACK, flag == proto.PUBREC, flag == proto.PUBREL, flag == proto.PUBCOMP:
+		if len(data) != 2 {
+			return nil, errMalformed
+		}
+		out = append(append([]byte{}, data...), proto.Success)
+
+	case flag == proto.SUBSCRIBE, flag == proto.UNSUBSCRIBE:
+		if len(data) < 2 {
+			return nil, errMalformed
+		}
+		out = insertByte(data, 2, 0)
+
+	case flag == proto.PINGREQ, flag == proto.DISCONNECT:
+		return f, nil
+
+	default:
+		return nil, errors.New("v3.1.1 不支持的报文")
+	}
+
+	return &proto.Fixed{
+		HeaderFlag: f.HeaderFlag,
+		MsgLen:     uint32(len(out)),
+		Data:       out,
+	}, nil
+}
+
+/*
+v5 报文转换成 v3.1.1 报文
+去掉属性和原因码，服务端不发送 DISCONNECT 和 AUTH
+*/
+func (s v311Codec) encode(by []byte) []byte {
+
+	flag, body, ok := splitPacket(by)
+	if !ok {
+		return by
+	}
+
+	if flag == proto.DISCONNECT || flag == proto.AUTH {
+		return nil
+	}
+
+	// PINGRESP 以外的报文至少有 2 个字节
+	if len(body) < 2 {
+		return by
+	}
+
+	var out []byte
+
+	switch {
+	case flag == proto.CONNACK:
+		out = []byte{body[0], v311ConnackCode(body[1])}
+
+	case flag&0xF0 == proto.PUBLISH:
+		n := 2 + (int(body[0])<<8 | int(body[1]))
+		if (flag>>1)&0x03 > 0 {
+			n += 2
+		}
+		if len(body) < n {
+			return by
+		}
+		props, ok := skipProperties(body[n:])
+		if !ok {
+			return by
+		}
+		out = append(append([]byte{}, body[:n]...), props...)
+
+	case flag == proto.PUBACK, flag == proto.PUBREC, flag == proto.PUBREL, flag == proto.PUBCOMP:
+		out = body[:2]
+
+	case flag == proto.SUBACK:
+		codes, ok := skipProperties(body[2:])
+		if !ok {
+			return by
+		}
+		out = append([]byte{}, body[:2]...)
+		for _, c := range codes {
+			if c >= 0x80 {
+				c = v311SubFailure
+			}
+			out = append(out, c)
+		}
+
+	case flag == proto.UNSUBACK:
+		out = body[:2]
+
+	default:
+		return by
+	}
+
+	return append(append([]byte{flag}, newMqttDataPack().msgLenCode(uint32(len(out)))...), out...)
+}
+
+/*
+v5 CONNACK 原因码转换成 v3.1.1 返回码
+*/
+func v311ConnackCode(code uint8) uint8 {
+	switch code {
+	case proto.Success:
+		return v311Accepted
+	case proto.UnsupportedPV:
+		return v311RefusedVersion
+	case proto.ClientInotv:
+		return v311RefusedIdentifier
+	case proto.BadUNorP:
+		return v311RefusedUserPSD
+	case proto.Notauthorized, proto.Banned:
+		return v311RefusedAuth
+	default:
+		return v311RefusedServer
+	}
+}
+
+/*
+拆分报文 返回报文类型和剩余数据
+*/
+func splitPacket(by []byte) (uint8, []byte, bool) {
+
+	if len(by) < 2 {
+		return 0, nil, false
+	}
+
+	var n, multiplier uint32 = 0, 1
+	for i := 1; i < len(by) && i <= 4; i++ {
+		n += uint32(by[i]&0x7F) * multiplier
+		if by[i] < 0x80 {
+			if uint32(len(by)-i-1) != n {
+				return 0, nil, false
+			}
+			return by[0], by[i+1:], true
+		}
+		multiplier *= 128
+	}
+
+	return 0, nil, false
+}
+
+/*
+跳过属性长度和属性，返回后面的数据
+*/
+func skipProperties(by []byte) ([]byte, bool) {
+
+	var n, multiplier uint32 = 0, 1
+	for i := 0; i < len(by) && i < 4; i++ {
+		n += uint32(by[i]&0x7F) * multiplier
+		if by[i] < 0x80 {
+			if uint32(len(by)-i-1) < n {
+				return nil, false
+			}
+			return by[i+1+int(n):], true
+		}
+		multiplier *= 128
+	}
+
+	return nil, false
+}
+
+/*
+在 i 位置插入一个字节，返回新的切片
+*/
+func insertByte(by []byte, i int, b byte) []byte {
+	out := make([]byte, 0, len(by)+1)
+	out = append(out, by[:i]...)
+	out = append(out, b)
+	return append(out, by[i:]...)
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+)
+
+// 加上固定头部
+func packet(flag uint8, body ...byte) []byte {
+	return append(append([]byte{flag}, newMqttDataPack().msgLenCode(uint32(len(body)))...), body...)
+}
+
+func TestNewCodec(t *testing.T) {
+
+	cases := []struct {
+		name  string
+		data  []byte
+		level uint8
+		code  uint8
+	}{
+		{"v5", []byte{0, 4, 'M', 'Q', 'T', 'T', 5}, levelV5, proto.Success},
+		{"v3.1.1", []byte{0, 4, 'M', 'Q', 'T', 'T', 4}, levelV311, proto.Success},
+		{"v3.1 使用 v3.1.1 拒绝", []byte{0, 6, 'M', 'Q', 'I', 's', 'd', 'p', 3}, levelV311, proto.UnsupportedPV},
+		{"v6", []byte{0, 4, 'M', 'Q', 'T', 'T', 6}, levelV5, proto.UnsupportedPV},
+		{"长度不够", []byte{0, 4, 'M', 'Q'}, levelV5, proto.Malformed_Packet},
+	}
+
+	for _, c := range cases {
+		co, code := newCodec(&proto.Fixed{HeaderFlag: proto.CONNECT, Data: c.data})
+		if co.level() != c.level || code != c.code {
+			t.Fatalf("%s: level %d code 0x%02x", c.name, co.level(), code)
+		}
+	}
+}
+
+func TestV311Decode(t *testing.T) {
+
+	cases := []struct {
+		name string
+		flag uint8
+		in   []byte
+		want []byte
+	}{
+		{"CONNECT 有遗嘱和用户名", proto.CONNECT,
+			[]byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0x86, 0, 60, 0, 2, 'c', '1', 0, 3, 'a', '/', 'b', 0, 1, 'x', 0, 1, 'u'},
+			[]byte{0, 4, 'M', 'Q', 'T', 'T', 5, 0x86, 0, 60, 0, 0, 2, 'c', '1', 0, 0, 3, 'a', '/', 'b', 0, 1, 'x', 0, 1, 'u'}},
+		{"CONNECT 没有遗嘱", proto.CONNECT,
+			[]byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 60, 0, 2, 'c', '1'},
+			[]byte{0, 4, 'M', 'Q', 'T', 'T', 5, 0x02, 0, 60, 0, 0, 2, 'c', '1'}},
+		{"PUBLISH QoS0", proto.PUBLISH,
+			[]byte{0, 3, 'a', '/', 'b', 'h', 'i'},
+			[]byte{0, 3, 'a', '/', 'b', 0, 'h', 'i'}},
+		{"PUBLISH QoS1", proto.PUBLISH | 0x02,
+			[]byte{0, 3, 'a', '/', 'b', 0, 7, 'h', 'i'},
+			[]byte{0, 3, 'a', '/', 'b', 0, 7, 0, 'h', 'i'}},
+		{"PUBACK", proto.PUBACK, []byte{0, 7}, []byte{0, 7, proto.Success}},
+		{"SUBSCRIBE", proto.SUBSCRIBE,
+			[]byte{0, 1, 0, 3, 'a', '/', 'b', 1},
+			[]byte{0, 1, 0, 0, 3, 'a', '/', 'b', 1}},
+		{"DISCONNECT 不变", proto.DISCONNECT, nil, nil},
+	}
+
+	for _, c := range cases {
+		f, err := v311Codec{}.decode(&proto.Fixed{HeaderFlag: c.flag, MsgLen: uint32(len(c.in)), Data: c.in})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !bytes.Equal(f.Data, c.want) || f.MsgLen != uint32(len(c.want)) {
+			t.Fatalf("%s: 想要 % x 收到 % x", c.name, c.want, f.Data)
+		}
+	}
+
+	// 转换后可以使用 v5 解析
+	in := []byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0xC6, 0, 60, 0, 2, 'c', '1', 0, 3, 'a', '/', 'b', 0, 1, 'x', 0, 1, 'u', 0, 1, 'p'}
+	f, _ := v311Codec{}.decode(&proto.Fixed{HeaderFlag: proto.CONNECT, MsgLen: uint32(len(in)), Data: in})
+	p := proto.NewCONNECTProtocol(f)
+	if err := p.UnPack(); err != nil {
+		t.Fatal(err)
+	}
+	if p.ClientID != "c1" || p.WillTopic != "a/b" || p.WillMessage != "x" || p.UserName != "u" || p.Password != "p" || !p.CleanStart {
+		t.Fatalf("CONNECT %+v", p)
+	}
+
+	// 格式错误
+	bad := []struct {
+		flag uint8
+		in   []byte
+	}{
+		{proto.CONNECT, []byte{0, 4, 'M', 'Q', 'T', 'T', 4}},
+		{proto.CONNECT, []byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0x02, 0, 60, 0, 9, 'c'}},
+		{proto.PUBLISH | 0x02, []byte{0, 3, 'a', '/', 'b', 0}},
+		{proto.PUBACK, []byte{0, 7, 0}},
+		{proto.AUTH, []byte{0}},
+	}
+	for _, c := range bad {
+		if _, err := (v311Codec{}).decode(&proto.Fixed{HeaderFlag: c.flag, Data: c.in}); err == nil {
+			t.Fatalf("0x%02x % x 应该返回错误", c.flag, c.in)
+		}
+	}
+}
+
+func TestV311Encode(t *testing.T) {
+
+	pack := newMqttDataPack()
+
+	connack := func(code uint8, present bool) []byte {
+		by, _ := pack.packCONNACK(&connAck{code: code, sessionPresent: present, reason: "x", receiveMaximum: 10})
+		return by
+	}
+	suback := func(codes ...uint8) []byte {
+		by, _ := proto.NewSUBACKProtocol(uint32(len(codes)), [2]byte{0, 5}, codes).Pack()
+		return by
+	}
+	disconnect, _ := pack.packDISCONNECT(disconnectProtocolError)
+
+	cases := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"CONNACK 接受", connack(proto.Success, true), packet(proto.CONNACK, 0x01, v311Accepted)},
+		{"CONNACK 用户名密码错误", connack(proto.BadUNorP, false), packet(proto.CONNACK, 0, v311RefusedUserPSD)},
+		{"CONNACK 未授权", connack(proto.Notauthorized, false), packet(proto.CONNACK, 0, v311RefusedAuth)},
+		{"CONNACK 其他原因码", connack(connackServerBusy, false), packet(proto.CONNACK, 0, v311RefusedServer)},
+		{"PUBACK 去掉原因码", packet(proto.PUBACK, 0, 7, 0x10, 0), packet(proto.PUBACK, 0, 7)},
+		{"SUBACK 失败都是 0x80", suback(0, 2, 0x87, 0xA2), packet(proto.SUBACK, 0, 5, 0, 2, 0x80, 0x80)},
+		{"UNSUBACK", packet(proto.UNSUBACK, 0, 5, 0, 0x11), packet(proto.UNSUBACK, 0, 5)},
+		{"DISCONNECT 不发送", disconnect, nil},
+		{"PINGRESP 不变", packet(proto.PINGRESP), packet(proto.PINGRESP)},
+	}
+
+	for _, c := range cases {
+		if got := (v311Codec{}).encode(c.in); !bytes.Equal(got, c.want) {
+			t.Fatalf("%s: 想要 % x 收到 % x", c.name, c.want, got)
+		}
+	}
+}
+
+func TestV311PublishRoundTrip(t *testing.T) {
+
+	cases := []struct {
+		name string
+		in   []byte
+	}{
+		{"QoS0", packet(proto.PUBLISH, 0, 3, 'a', '/', 'b', 'h', 'i')},
+		{"QoS1", packet(proto.PUBLISH|0x02, 0, 3, 'a', '/', 'b', 0, 7, 'h', 'i')},
+		{"QoS2 空载荷", packet(proto.PUBLISH|0x04, 0, 1, 'a', 0x12, 0x34)},
+	}
+
+	for _, c := range cases {
+		flag, body, _ := splitPacket(c.in)
+
+		// v3.1.1 收到后转换成 v5 解析
+		f, err := v311Codec{}.decode(&proto.Fixed{HeaderFlag: flag, MsgLen: uint32(len(body)), Data: body})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		p := proto.NewPUBLISHProtocol(f)
+		if err := p.UnPack(); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		// 转发时使用 v5 打包，有消息过期属性，发送给 v3.1.1 时去掉
+		msg := newMessage(p.TopicName, p.Payload, p.Qos, 60)
+		by, err := newMqttDataPack().packPUBLISH(msg, p.MsgId, false, 0, false)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := (v311Codec{}).encode(by); !bytes.Equal(got, c.in) {
+			t.Fatalf("%s: 想要 % x 收到 % x", c.name, c.in, got)
+		}
+
+		// v5 链接不转换
+		if got := (v5Codec{}).encode(by); !bytes.Equal(got, by) {
+			t.Fatalf("%s: v5 % x", c.name, got)
+		}
+	}
+}
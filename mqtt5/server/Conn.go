@@ -1,24 +1,41 @@
 package server
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
-	"fmt"
 	"github.com/guihai/ghmqtt/mqtt5/proto"
 	"github.com/guihai/ghmqtt/utils"
-	"github.com/guihai/ghmqtt/utils/zaplog"
-	"go.uber.org/zap"
+	"github.com/guihai/ghmqtt/utils/ghlog"
+	"github.com/guihai/ghmqtt/utils/wsconn"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Conn struct {
-	netConn  *net.TCPConn // 原始测tcp 链接对象
-	clientID string       // mqtt 生成 clientid  在第一次链接的时候获取
-	isClose  bool         // 是否关闭链接   true 标识关闭，false 未关闭
+	netConn  net.Conn // 原始链接对象 tcp 或者 tls
+	clientID string   // mqtt 生成 clientid  在第一次链接的时候获取
+	isClose  bool     // 是否关闭链接   true 标识关闭，false 未关闭
+
+	// 链接信息 地址，证书身份等
+	info *ConnInfo
 
 	writerBuffChan chan []byte // 写数据通道 有缓冲
+	// 写缓冲，合并队列中的报文后一次写入
+	bufWriter *bufio.Writer
+	// 发送队列满时暂存的报文，spill 方式使用，QoS1，QoS2 的 PUBLISH 退回会话队列不暂存
+	spillList [][]byte
+	spillLen  int32
+	spillLock sync.Mutex
+	// 有暂存报文通知写协程
+	spillChan chan struct{}
+	// 会话因为发送队列已满停止发送，写协程发送后通知会话继续  1 等待
+	queueWait int32
 
 	// 上下文管理 管理关闭
 	ctx context.Context
@@ -26,6 +43,8 @@ type Conn struct {
 
 	//当前Conn属于哪个Server 方便调用server中的 链接管理器和路由管理器
 	ofServer *Server
+	// 当前Conn属于哪个监听
+	ofListener *Listener
 
 	// 链接属性，方便业务中使用
 	keyValue map[string]interface{}
@@ -35,52 +54,137 @@ type Conn struct {
 	// 超时关闭链接设置
 	// 活跃在线通道，客户请求后 将true置入此通道  无缓冲阻塞
 	liveChan chan bool
-	// 活跃时间设置  单位秒  1，可以设置参数；2可以和客户端的live时间 成比例
-	liveTime uint8
+	// 活跃时间，超过时间没有收到数据关闭链接
+	// CONNECT 之前使用 ConnLiveTime，之后是客户端 KeepAlive 的 1.5 倍，0 不超时
+	liveTime time.Duration
+
+	// 超过新链接速率限制的原因，不为空时拒绝链接
+	rateReason string
+
+	// 占用连接数名额的用户名，finalStop 时释放
+	limitUser string
+	// 是否占用了连接数名额，被接管时转给新链接，使用 ConnLimit 的 lock
+	limited bool
+
+	// 服务关闭中，停止读取客户端数据  1 关闭中
+	shutting int32
+	// 服务关闭，写完队列中的数据后关闭链接
+	drainChan chan struct{}
+	drainOnce sync.Once
+	// 关闭前最后发送的报文 DISCONNECT
+	lastPacket []byte
+
+	// 客户端最大报文长度，超过的 PUBLISH 不转发，0 不限制
+	maxPacketSize uint32
+	// 客户端接收最大数量，发送中的 QoS1，QoS2 消息达到后保存到会话队列
+	receiveMaximum uint16
+	// 收到还没有返回 PUBACK 的 QoS1 消息数
+	receiving int32
+	// 收到还没有返回 PUBCOMP 的 QoS2 报文标识符，按标识符计数
+	receivingIDs map[uint16]struct{}
+	receiveLock  sync.Mutex
+
+	// 服务端主题别名最大值，CONNECT 时从配置获取
+	topicAliasMaximum uint16
+	// 收到的主题别名，只在读协程中使用
+	aliasIn map[uint16]string
+	// 发送的主题别名
+	aliasOut *topicAliases
+
+	// 协议编解码，CONNECT 时根据协议级别选择，之前使用 v5
+	codec codec
+
+	// 会话，CONNECT 成功后设置
+	session *Session
+	// CONNECT 中的会话过期间隔，为 0 时 DISCONNECT 不能改为非 0
+	connectExpiry uint32
 }
 
-func newConn(conn *net.TCPConn, ser *Server) *Conn {
+func newConn(conn net.Conn, lis *Listener) *Conn {
 	c := &Conn{
 		netConn: conn,
 		isClose: false,
 
-		ofServer: ser,
+		info: &ConnInfo{
+			Listener: lis.getConfig().Name,
+		},
+
+		ofServer:   lis.ofServer,
+		ofListener: lis,
 
 		// 有缓冲写入通道
-		writerBuffChan: make(chan []byte, utils.GO.MaxPacketSize), // 返回写数据通道
+		writerBuffChan: make(chan []byte, lis.ofServer.getConfig().WriteQueueSize), // 返回写数据通道
+		bufWriter:      bufio.NewWriter(conn),
+		spillChan:      make(chan struct{}, 1),
 
 		// 初始化属性
 		keyValue: make(map[string]interface{}),
 
 		// 初始化活跃通道
 		liveChan: make(chan bool),
-		liveTime: utils.GO.ConnLiveTime,
+		liveTime: lis.ofServer.connectTimeout(),
+
+		drainChan: make(chan struct{}),
+
+		codec: v5Codec{},
 	}
+
+	// 开启上下文 管理
+	c.ctx, c.cal = context.WithCancel(context.Background())
+
 	return c
 }
 
 func (s *Conn) start() {
 
-	// 开启上下文 管理
-	s.ctx, s.cal = context.WithCancel(context.Background())
+	// 服务关闭时等待链接协程退出
+	defer s.ofServer.connWg.Done()
+
+	// 客户端地址，PROXY 协议的头部在这里读取，不阻塞监听接收链接
+	s.info.RemoteAddr = s.netConn.RemoteAddr().String()
+
+	// 新链接速率限制，使用真实地址
+	if ok, reason := s.ofServer.connRate.check(s.info.RemoteAddr); !ok {
+		s.rateReason = reason
+		s.getLogger().Warn("【拒绝链接】" + reason)
+	}
+
+	// tls 链接 先握手 获取客户端证书
+	err := s.tlsHandshake()
+	if err != nil {
+		s.getLogger().Error("【错误】TLS握手错误", ghlog.Err(err))
+		s.finalStop()
+		return
+	}
 
 	// 第一次链接 要设置 client 仅设置一次
-	err := s.setClientID()
+	err = s.setClientID()
 	if err != nil {
-		zaplog.ZapLogger.Error("【错误】连接启动错误" + err.Error())
+		s.getLogger().Error("【错误】连接启动错误", ghlog.Err(err))
 		// 关闭链接
 		s.finalStop()
 		return
 	}
 
+	s.ofServer.connWg.Add(2)
+
 	// 读数据
 	go s.read()
 
 	// 写数据
 	go s.write()
 
+	// 写协程开启后重发没有确认的消息，发送离线消息，超过发送队列剩余空间的留在会话中
+	s.session.resume(s)
+
 	// 开启监听 上下文是否关闭
 	for {
+		// 保活时间为 0 不超时
+		var timeout <-chan time.Time
+		if s.liveTime > 0 {
+			timeout = time.After(s.liveTime)
+		}
+
 		select {
 		case <-s.ctx.Done(): // 上下文关闭了
 			// 执行最终关闭链接
@@ -90,8 +194,18 @@ func (s *Conn) start() {
 		case <-s.liveChan:
 			// 活跃通道获取数据，不操作 执行下一次循环
 			continue
-		case <-time.After(time.Duration(s.liveTime) * time.Second):
-			// 超过活跃时间了，执行关闭
+		case <-timeout:
+			// 超过活跃时间了，v5 发送 DISCONNECT 保活超时 后关闭
+			s.getLogger().Info("【保活超时】", ghlog.Duration("liveTime", s.liveTime))
+
+			s.shutdown(disconnectKeepAliveTimeout)
+
+			// 等待写协程发送完成，写阻塞时直接关闭
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(s.ofServer.connectTimeout()):
+			}
+
 			s.finalStop()
 			return
 		}
@@ -99,6 +213,25 @@ func (s *Conn) start() {
 	}
 }
 
+/*
+根据客户端 KeepAlive 设置活跃时间
+服务端设置了 MaxKeepAlive 时，v5 客户端为 0 或者超过最大值使用最大值，返回服务端保活时间，需要通知客户端
+3.1.1 没有 Server Keep Alive，无法通知客户端，使用客户端的值
+*/
+func (s *Conn) setKeepAlive(keepAlive uint16) (serverKeepAlive uint16) {
+
+	max := s.ofServer.getConfig().MaxKeepAlive
+	if max > 0 && s.codec.level() == levelV5 && (keepAlive == 0 || keepAlive > max) {
+		keepAlive = max
+		serverKeepAlive = max
+	}
+
+	// 1.5 倍保活时间
+	s.liveTime = time.Duration(keepAlive) * 1500 * time.Millisecond
+
+	return serverKeepAlive
+}
+
 // 关闭链接
 func (s *Conn) stop() {
 	// 使用上下文方法 关闭  cal()执行后 Start()方法中 ctx.done 就会收到
@@ -107,7 +240,7 @@ func (s *Conn) stop() {
 
 func (s *Conn) read() {
 
-	defer s.stop()
+	defer s.ofServer.connWg.Done()
 
 	for {
 
@@ -122,13 +255,55 @@ func (s *Conn) read() {
 			err := req.getMqttProto()
 
 			if err != nil {
+				// 服务关闭中 停止读取，链接由写协程发送完数据后关闭
+				if atomic.LoadInt32(&s.shutting) == 1 {
+					return
+				}
 				// 获取协议错误，直接退出方法
-				fmt.Println("err===", err)
+				if err == errPacketTooLarge {
+					// 报文过长，v5 发送 DISCONNECT 后关闭
+					s.getLogger().Warn("【报文过长】断开链接", ghlog.Uint32("max", s.ofServer.getConfig().MaxPacketSize))
+					s.shutdown(disconnectPacketTooLarge)
+					return
+				}
+				s.getLogger().Warn("【读取报文错误】断开链接", ghlog.Err(err))
+				s.stop()
 				return
 			}
 
 			// 更新状态
-			s.liveChan <- true
+			select {
+			case s.liveChan <- true:
+			case <-s.ctx.Done():
+				return
+			}
+
+			// DISCONNECT 修改会话过期间隔，在路由之前处理
+			// CONNECT 中为 0 时协议错误，不修改，断开链接并且发送遗嘱
+			if dp, ok := req.proto.(*proto.DISCONNECTProtocol); ok && dp.SessionExpirySet {
+				if !s.updateSessionExpiry(dp.SessionExpiryInterval) {
+					s.getLogger().Warn("【会话过期间隔】CONNECT 中为 0，不能修改，断开链接", ghlog.Uint32("expiry", dp.SessionExpiryInterval))
+					s.shutdown(disconnectProtocolError)
+					return
+				}
+			}
+
+			// 主题别名替换成主题，在路由之前处理
+			if code := s.resolveTopicAlias(req.proto); code != 0 {
+				s.getLogger().Warn("【主题别名错误】断开链接", ghlog.Uint8("code", code))
+				s.shutdown(code)
+				return
+			}
+
+			// 订阅者的确认，更新会话中发送中的消息，在路由之前处理
+			req.inflightFound = s.ackInflight(req.proto)
+
+			// 超过服务端接收最大数量，断开链接
+			if !s.checkReceive(req.proto) {
+				s.getLogger().Warn("【超过接收最大数量】断开链接", ghlog.Uint16("max", s.ofServer.getConfig().ReceiveMaximum))
+				s.shutdown(disconnectReceiveMaximum)
+				return
+			}
 
 			// 使用协程池
 			if s.ofServer.routerMer.workPoolIsOn() {
@@ -146,33 +321,24 @@ func (s *Conn) read() {
 
 }
 
-func (s *Conn) write() {
-
-	for {
-
-		select {
-		case <-s.ctx.Done(): // 上下文关闭了 退出方法
-			return
-		case data, ok := <-s.writerBuffChan:
-
-			// 有缓冲通道
-			if ok {
-				//有数据要写给客户端
-				if _, err := s.netConn.Write(data); err != nil {
-					//fmt.Println("写给客户端数据失败:, ", err, "连接退出")
-					return
-				}
-			} else {
-				// 有缓冲写通道关闭了
-				break
-			}
-
-		default:
-			// 将 需要写入的数据
+/*
+服务关闭 第一步，停止读取客户端数据
+还在等待 CONNECT 的链接会读取失败直接关闭
+*/
+func (s *Conn) stopRead() {
+	atomic.StoreInt32(&s.shutting, 1)
+	s.netConn.SetReadDeadline(time.Now())
+}
 
-		}
+/*
+链接的日志，带上客户端地址，监听名称，CONNECT 之后带上客户标识符
+*/
+func (s *Conn) getLogger() ghlog.Logger {
+	fields := []ghlog.Field{ghlog.Addr(s.info.RemoteAddr), ghlog.Listener(s.ofListener.getConfig().Name)}
+	if s.clientID != "" {
+		fields = append(fields, ghlog.Client(s.clientID))
 	}
-
+	return s.ofServer.logger.With(fields...)
 }
 
 func (s *Conn) getClientID() string {
@@ -196,21 +362,113 @@ func (s *Conn) setClientID() error {
 		return errors.New("解析协议错误")
 	}
 
+	// 协议级别，链接验证中可以区分版本
+	s.info.Version = p.Version
+
+	// 新链接速率限制，读取 CONNECT 后再拒绝，客户端可以收到 CONNACK
+	if code == 0 && s.rateReason != "" {
+		ack := &connAck{code: connackRateExceeded, reason: s.rateReason}
+		if err := request.sendCONNACK(ack); err != nil {
+			return err
+		}
+
+		return errors.New("链接速率超过限制 " + s.rateReason)
+	}
+
+	// 监听要求 用户名密码
+	if code == 0 && s.ofListener.getConfig().RequireAuth && (p.UserName == "" || p.Password == "") {
+		code = proto.BadUNorP
+	}
+
 	if code == 0 {
 
+		// 使用证书身份作为 clientID
+		if s.info.CertIdentity != "" {
+			p.ClientID = s.info.CertIdentity
+			p.ClientIDLength = uint16(len(p.ClientID))
+		}
+	}
+
+	assignedClientID := ""
+	if code == 0 && p.ClientID == "" {
+		// clientID 为空，3.1.1 必须是新会话，v5 在 CONNACK 中返回分配的 clientID
+		if s.codec.level() == levelV311 && !p.CleanStart {
+			code = proto.ClientInotv
+		} else {
+			p.ClientID = s.assignClientID()
+			p.ClientIDLength = uint16(len(p.ClientID))
+			assignedClientID = p.ClientID
+		}
+	}
+
+	if code == 0 {
 		// code = 0 可以进行链接 需要接入自定义的链接验证 链接验证仅执行一次
-		code = s.ofServer.routerMer.connectVerify(p)
+		code = s.ofServer.routerMer.connectVerify(p, s.info)
+	}
+
+	ack := &connAck{code: code, assignedClientID: assignedClientID}
+
+	// 相同 clientID 的链接已经在线，按配置拒绝新链接，否则在使用会话时接管
+	if code == 0 && s.ofServer.getConfig().DuplicateClient == utils.DuplicateReject {
+		if _, err := s.ofServer.connMer.getConn(p.ClientID); err == nil {
+			ack.code = connackClientIDInUse
+			s.getLogger().Warn("【拒绝链接】clientID 已经在线", ghlog.Client(p.ClientID))
+		}
+	}
+
+	if ack.code == 0 {
+		// 连接数限制，相同 clientID 的链接在线并且会被接管时，使用它的名额
+		var old *Conn
+		if s.ofServer.getConfig().DuplicateClient != utils.DuplicateReject {
+			old, _ = s.ofServer.connMer.getConn(p.ClientID)
+		}
+		limit, reason := s.ofServer.connLimit.acquire(s, p.UserName, old)
+
+		switch limit {
+		case limitBusy:
+			ack.code, ack.reason = connackServerBusy, reason
+		case limitQuota:
+			ack.code, ack.reason = connackQuotaExceeded, reason
+		}
 
-		if code == 0 {
-			// 根据协议，在路由管理器中搜索对应的路由对象，执行方法
-			s.ofServer.routerMer.doRouterFunc(request)
+		if limit != limitOK {
+			s.getLogger().Warn("【拒绝链接】"+reason, ghlog.Client(p.ClientID), ghlog.String("user", p.UserName))
+		}
+	}
+
+	code = ack.code
 
+	if code == 0 {
+		// 客户端保活时间
+		ack.serverKeepAlive = s.setKeepAlive(p.KeepAlive)
+		// 最大报文长度
+		ack.maxPacketSize = s.ofServer.getConfig().MaxPacketSize
+		s.maxPacketSize = clientMaxPacketSize(p)
+		// 接收最大数量
+		ack.receiveMaximum = s.ofServer.getConfig().ReceiveMaximum
+		s.receiveMaximum = clientReceiveMaximum(p)
+		// 主题别名最大值
+		ack.topicAliasMaximum = s.ofServer.getConfig().TopicAliasMaximum
+		s.topicAliasMaximum = ack.topicAliasMaximum
+		s.aliasOut = newTopicAliases(clientTopicAliasMaximum(p))
+
+		// 会话过期间隔，超过服务端上限时在 CONNACK 中返回
+		expiry := s.sessionExpiry(p)
+		s.connectExpiry = expiry
+		if e, lowered := s.ofServer.sessMer.capExpiry(expiry); lowered {
+			expiry = e
+			ack.sessionExpiry = e
 		}
 
+		// 使用会话，离线消息在 CONNACK 之后发送，会话的原来链接断开
+		s.session, ack.sessionPresent = s.ofServer.sessMer.open(s, p.ClientID, p.CleanStart, expiry)
+
+		// 根据协议，在路由管理器中搜索对应的路由对象，执行方法
+		s.ofServer.routerMer.doRouterFunc(request)
 	}
 
 	// 返回确认消息
-	err2 := request.sendCONNACK(code)
+	err2 := request.sendCONNACK(ack)
 
 	if err2 != nil {
 		return err2
@@ -237,6 +495,9 @@ func (s *Conn) setClientID() error {
 			WillMessage: p.WillMessage,
 			WillRetain:  p.WillRetain,
 			WillQos:     p.WillQos,
+
+			WillDelayInterval:     p.WillDelayInterval,
+			MessageExpiryInterval: p.MessageExpiryInterval,
 		})
 	}
 
@@ -244,16 +505,251 @@ func (s *Conn) setClientID() error {
 
 }
 
+/*
+生成 clientID，默认 gh- 加 16 位随机十六进制，已经有会话时重新生成
+*/
+func (s *Conn) assignClientID() string {
+	if s.ofServer.clientIDFunc != nil {
+		return s.ofServer.clientIDFunc(s.info)
+	}
+
+	by := make([]byte, 8)
+	for {
+		rand.Read(by)
+		id := "gh-" + hex.EncodeToString(by)
+		if _, ok := s.ofServer.sessMer.getSession(id); !ok {
+			return id
+		}
+	}
+}
+
+/*
+CONNECT 请求的会话过期间隔
+3.1.1 CleanSession=0 的会话不过期，CleanSession=1 链接断开时删除
+*/
+func (s *Conn) sessionExpiry(p *proto.CONNECTProtocol) uint32 {
+	if s.codec.level() == levelV311 {
+		if p.CleanStart {
+			return 0
+		}
+		return sessionNeverExpire
+	}
+
+	return p.SessionExpiryInterval
+}
+
+/*
+DISCONNECT 中的会话过期间隔，不超过服务端上限
+CONNECT 中为 0 时改为非 0 是协议错误，不修改，返回 false
+*/
+func (s *Conn) updateSessionExpiry(expiry uint32) bool {
+	if s.connectExpiry == 0 && expiry > 0 {
+		return false
+	}
+	if s.session == nil {
+		return true
+	}
+
+	expiry, _ = s.ofServer.sessMer.capExpiry(expiry)
+	s.session.updateExpiry(s, expiry)
+
+	return true
+}
+
+/*
+订阅者的 PUBACK，PUBREC，PUBCOMP 更新会话中发送中的消息
+PUBREC 之后的 PUBREL 由路由发送，报文标识符不存在或者状态不对时返回 false
+*/
+func (s *Conn) ackInflight(p proto.ImplMqttProto) bool {
+	if s.session == nil {
+		return false
+	}
+
+	var id uint16
+	var code uint8
+
+	switch ap := p.(type) {
+	case *proto.PUBACKProtocol:
+		id, code = ap.MsgId, ap.ReasonCode
+	case *proto.PUBRECProtocol:
+		id, code = ap.MsgId, ap.ReasonCode
+	case *proto.PUBCOMPProtocol:
+		id, code = ap.MsgId, ap.ReasonCode
+	default:
+		return false
+	}
+
+	if !s.session.ackInflight(p.GetHeaderFlag(), id, code, s.ofServer.getConfig().SessionQueueSize) {
+		s.getLogger().Debug("【确认】没有发送中的消息", ghlog.Packet(proto.PacketName(p.GetHeaderFlag())), ghlog.Uint16("id", id))
+		return false
+	}
+
+	return true
+}
+
+/*
+收到 QoS1，QoS2 的 PUBLISH，增加接收中的消息数
+v5 客户端超过服务端的接收最大数量时返回 false
+QoS2 按报文标识符计数，已经计数或者已经保存在会话中的标识符不再计数，不管是否设置 DUP
+*/
+func (s *Conn) checkReceive(p proto.ImplMqttProto) bool {
+	sp, ok := p.(*proto.PUBLISHProtocol)
+	if !ok || sp.Qos == proto.QoS0 || s.session == nil {
+		return true
+	}
+
+	var n int
+	if sp.Qos == proto.QoS1 {
+		n = int(atomic.AddInt32(&s.receiving, 1))
+
+		s.receiveLock.Lock()
+		n += len(s.receivingIDs)
+		s.receiveLock.Unlock()
+	} else {
+		s.receiveLock.Lock()
+		if _, ok := s.receivingIDs[sp.MsgId]; ok || s.session.getQos2ID(sp.MsgId) {
+			s.receiveLock.Unlock()
+			return true
+		}
+		if s.receivingIDs == nil {
+			s.receivingIDs = make(map[uint16]struct{})
+		}
+		s.receivingIDs[sp.MsgId] = struct{}{}
+		n = len(s.receivingIDs) + int(atomic.LoadInt32(&s.receiving))
+		s.receiveLock.Unlock()
+	}
+
+	max := s.ofServer.getConfig().ReceiveMaximum
+	if max == 0 || s.codec.level() == levelV311 {
+		return true
+	}
+
+	return n <= int(max)
+}
+
+/*
+发送 PUBACK，PUBCOMP，或者拒绝 QoS2 的 PUBREC 时完成收到的消息，减少接收中的消息数
+在加入发送队列前处理，路由不通过 SendRES 发送确认也会释放
+*/
+func (s *Conn) ackReceived(by []byte) {
+	if len(by) < 2 {
+		return
+	}
+
+	flag := by[0] & 0xF0
+	if flag != proto.PUBACK && flag != proto.PUBREC && flag != proto.PUBCOMP {
+		return
+	}
+
+	// 跳过固定报头的剩余长度，变长整数最多 4 个字节
+	i := 1
+	for i < 4 && i < len(by) && by[i]&0x80 != 0 {
+		i++
+	}
+	i++
+	if len(by) < i+2 {
+		return
+	}
+	id := uint16(by[i])<<8 | uint16(by[i+1])
+
+	switch flag {
+	case proto.PUBACK:
+		s.received()
+	case proto.PUBCOMP:
+		s.receivedQos2(id)
+	case proto.PUBREC:
+		// 没有原因码是 0x00 成功
+		if len(by) > i+2 && by[i+2] >= 0x80 {
+			s.receivedQos2(id)
+		}
+	}
+}
+
+/*
+返回 PUBACK 后，减少接收中的 QoS1 消息数
+*/
+func (s *Conn) received() {
+	for {
+		n := atomic.LoadInt32(&s.receiving)
+		if n <= 0 {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&s.receiving, n, n-1) {
+			return
+		}
+	}
+}
+
+/*
+返回 PUBCOMP，或者拒绝 QoS2 的 PUBREC 后，删除接收中的 QoS2 报文标识符
+*/
+func (s *Conn) receivedQos2(id uint16) {
+	s.receiveLock.Lock()
+	delete(s.receivingIDs, id)
+	s.receiveLock.Unlock()
+}
+
+/*
+tls 链接握手，获取客户端证书信息
+wss 链接在 http 层已经握手，直接获取
+非 tls 链接不处理
+*/
+func (s *Conn) tlsHandshake() error {
+
+	var state tls.ConnectionState
+
+	switch c := s.netConn.(type) {
+	case *tls.Conn:
+		// 握手超时
+		c.SetDeadline(time.Now().Add(s.ofServer.connectTimeout()))
+		err := c.Handshake()
+		c.SetDeadline(time.Time{})
+
+		if err != nil {
+			return err
+		}
+
+		state = c.ConnectionState()
+
+	case *wsconn.Conn:
+		if c.TLSState() == nil {
+			return nil
+		}
+
+		state = *c.TLSState()
+
+	default:
+		return nil
+	}
+
+	s.info.TLS = true
+
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+
+		s.info.CertCommonName = cert.Subject.CommonName
+		s.info.CertSANs = utils.CertSANs(cert)
+		s.info.CertIdentity = utils.CertIdentity(cert, s.ofListener.getConfig().TLS.IdentityFrom)
+	}
+
+	return nil
+}
+
 // 获取socket 套接字
-func (s *Conn) getTcpConn() *net.TCPConn {
+func (s *Conn) getNetConn() net.Conn {
 	return s.netConn
 }
 
 /*
-写通道接收数据
+直接写入链接，不经过写通道
+写协程开启前使用
 */
-func (s *Conn) sendByte(by []byte) {
-	s.writerBuffChan <- by
+func (s *Conn) writeNow(by []byte) error {
+	s.netConn.SetWriteDeadline(time.Now().Add(s.ofServer.connectTimeout()))
+	_, err := s.netConn.Write(by)
+	s.netConn.SetWriteDeadline(time.Time{})
+
+	return err
 }
 
 /*
@@ -268,23 +764,24 @@ func (s *Conn) finalStop() {
 
 	s.isClose = true
 
-	// 关闭写通道
-	close(s.writerBuffChan)
-	// 关闭 livechan
-	close(s.liveChan)
+	// 通知读写协程退出
+	s.cal()
 
 	s.netConn.Close()
 
-	// 发送遗嘱
-	s.ofServer.topicMer.sendClientWill(s.clientID)
+	// 监听中移出，链接数减少
+	s.ofListener.removeConn(s)
+
+	// 释放连接数名额
+	s.ofServer.connLimit.release(s)
 
-	// 删除遗嘱
-	s.ofServer.topicMer.removeClientWill(s.clientID)
+	// 会话离线，发送或者延迟发送遗嘱，不保留的会话删除
+	s.ofServer.sessMer.close(s)
 
 	// 链接管理器中移出
-	s.ofServer.connMer.removeConn(s.clientID)
+	s.ofServer.connMer.removeConn(s)
 
-	zaplog.ZapLogger.Info("【连接关闭】", zap.String("client", s.clientID))
+	s.getLogger().Info("【连接关闭】")
 
 }
 
@@ -0,0 +1,26 @@
+package server
+
+/*
+CONNACK 响应参数
+v5 除返回码外都作为属性发送，3.1.1 只使用返回码
+*/
+type connAck struct {
+	// 返回码
+	code uint8
+	// 原因字符串，拒绝链接时说明原因
+	reason string
+	// 服务端保活时间，不为 0 时客户端使用此值
+	serverKeepAlive uint16
+	// 服务端最大报文长度，0 不限制
+	maxPacketSize uint32
+	// 服务端接收最大数量，0 不返回
+	receiveMaximum uint16
+	// 服务端主题别名最大值，0 不返回
+	topicAliasMaximum uint16
+	// 继续使用原来的会话
+	sessionPresent bool
+	// 服务端降低后的会话过期间隔，0 使用客户端的值
+	sessionExpiry uint32
+	// 服务端分配的 clientID
+	assignedClientID string
+}
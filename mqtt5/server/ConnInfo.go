@@ -0,0 +1,22 @@
+package server
+
+/*
+链接信息，链接验证和业务路由中使用
+*/
+type ConnInfo struct {
+	// 客户端地址，监听开启 PROXY 协议时是头部中的真实地址
+	RemoteAddr string
+	// 所属监听名称
+	Listener string
+	// 协议级别 4 是 3.1.1，5 是 5.0
+	Version uint8
+
+	// 是否是 TLS 链接
+	TLS bool
+	// 客户端证书 CommonName，双向认证时才有值
+	CertCommonName string
+	// 客户端证书 SAN 列表
+	CertSANs []string
+	// 根据配置从证书中获取的身份，为空表示不使用证书身份
+	CertIdentity string
+}
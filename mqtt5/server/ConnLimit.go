@@ -0,0 +1,156 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 连接数限制结果
+const (
+	limitOK    uint8 = iota // 可以链接
+	limitBusy               // 超过服务或者监听的最大连接数
+	limitQuota              // 超过用户的最大连接数
+)
+
+/*
+连接数限制
+CONNECT 验证通过后占用名额，链接最终关闭时释放
+1，服务最大连接数 MaxConn
+2，监听最大连接数 ListenerConfig.MaxConn
+3，每个用户名最大连接数 MaxConnPerUser
+0 表示不限制
+*/
+type ConnLimit struct {
+	lock sync.Mutex
+
+	// 所属服务，读取配置
+	ofServer *Server
+
+	// 服务连接数
+	total uint32
+	// 监听连接数 监听名称是key
+	listeners map[string]uint32
+	// 用户连接数 用户名是key
+	users map[string]uint32
+
+	// 拒绝次数
+	rejectBusy  uint64
+	rejectQuota uint64
+}
+
+func newConnLimit(ser *Server) *ConnLimit {
+	return &ConnLimit{
+		ofServer:  ser,
+		listeners: make(map[string]uint32),
+		users:     make(map[string]uint32),
+	}
+}
+
+/*
+链接占用名额，返回限制结果和原因
+old 是相同 clientID 已经在线，将被接管的链接，它的名额先转给新链接，重新链接不会因为旧链接超过限制
+*/
+func (s *ConnLimit) acquire(conn *Conn, user string, old *Conn) (uint8, string) {
+
+	cfg := s.ofServer.getConfig()
+	lis := conn.ofListener.getConfig()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	total, lisN, userN := s.total, s.listeners[lis.Name], s.users[user]
+
+	// 旧链接的名额转给新链接
+	transfer := old != nil && old.limited
+	var oldLis string
+	if transfer {
+		oldLis = old.ofListener.getConfig().Name
+		total--
+		if oldLis == lis.Name {
+			lisN--
+		}
+		if old.limitUser == user {
+			userN--
+		}
+	}
+
+	if cfg.MaxConn > 0 && total >= cfg.MaxConn {
+		atomic.AddUint64(&s.rejectBusy, 1)
+		return limitBusy, "超过服务最大连接数"
+	}
+
+	if lis.MaxConn > 0 && lisN >= lis.MaxConn {
+		atomic.AddUint64(&s.rejectBusy, 1)
+		return limitBusy, "超过监听 " + lis.Name + " 最大连接数"
+	}
+
+	if user != "" && cfg.MaxConnPerUser > 0 && userN >= cfg.MaxConnPerUser {
+		atomic.AddUint64(&s.rejectQuota, 1)
+		return limitQuota, "超过用户最大连接数"
+	}
+
+	if transfer {
+		old.limited = false
+		s.remove(oldLis, old.limitUser)
+	}
+
+	s.total++
+	s.listeners[lis.Name]++
+	if user != "" {
+		s.users[user]++
+	}
+
+	conn.limited = true
+	conn.limitUser = user
+
+	return limitOK, ""
+}
+
+/*
+链接释放名额，名额已经转给新链接时不处理
+*/
+func (s *ConnLimit) release(conn *Conn) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !conn.limited {
+		return
+	}
+	conn.limited = false
+
+	s.remove(conn.ofListener.getConfig().Name, conn.limitUser)
+}
+
+/*
+减少计数，需要持有 lock
+*/
+func (s *ConnLimit) remove(lis string, user string) {
+	s.total--
+
+	s.listeners[lis]--
+	if s.listeners[lis] == 0 {
+		delete(s.listeners, lis)
+	}
+
+	if user != "" {
+		s.users[user]--
+		if s.users[user] == 0 {
+			delete(s.users, user)
+		}
+	}
+}
+
+/*
+限制信息
+*/
+func (s *ConnLimit) getInfo() map[string]interface{} {
+	cfg := s.ofServer.getConfig()
+
+	return map[string]interface{}{
+		"MaxConn":        cfg.MaxConn,
+		"MaxConnPerUser": cfg.MaxConnPerUser,
+		"RejectBusy":     atomic.LoadUint64(&s.rejectBusy),
+		"RejectQuota":    atomic.LoadUint64(&s.rejectQuota),
+	}
+}
@@ -2,7 +2,7 @@ package server
 
 import (
 	"errors"
-	"github.com/guihai/ghmqtt/utils/zaplog"
+	"github.com/guihai/ghmqtt/mqtt5/server/types"
 	"sync"
 )
 
@@ -12,18 +12,23 @@ type ConnManager struct {
 
 	// map 的读写锁
 	mapLock sync.RWMutex
+
+	// 所属服务
+	ofServer *Server
 }
 
-func newConnManager() *ConnManager {
+func newConnManager(ser *Server) *ConnManager {
 	return &ConnManager{
 		connMap: make(map[string]*Conn),
 		//mapLock: sync.RWMutex{},
+
+		ofServer: ser,
 	}
 }
 
 /*
 添加链接
-1.如果验证是否已存在，新的链接不能替换原来的，实际中可能需要新的来替换旧的，所以不要验证是否已存在，
+不验证是否已存在，相同 clientID 的新链接已经接管会话，替换原来的链接
 */
 
 func (s *ConnManager) addConn(conn *Conn) bool {
@@ -71,14 +76,20 @@ func (s *ConnManager) getConn(clientID string) (*Conn, error) {
 /*
 在线链接列表
 */
-func (s *ConnManager) getConnList() []string {
+func (s *ConnManager) getConnList() []*types.ConnItem {
 	// 设定长度，容量
-	list := make([]string, 0, s.getLen())
+	list := make([]*types.ConnItem, 0, s.getLen())
 	// 上读锁
 	s.mapLock.RLock()
 
-	for client, _ := range s.connMap {
-		list = append(list, client)
+	for client, conn := range s.connMap {
+		list = append(list, &types.ConnItem{
+			ClientID:   client,
+			RemoteAddr: conn.info.RemoteAddr,
+			Listener:   conn.info.Listener,
+			Version:    conn.info.Version,
+			QueueLen:   conn.queueLen(),
+		})
 	}
 	// 解读锁
 	s.mapLock.RUnlock()
@@ -89,12 +100,14 @@ func (s *ConnManager) getConnList() []string {
 /*
 移出 对象
 1,无需验证是否存在，不存在删除也不报错
-2，删除对象
+2，已经被相同 clientID 的新链接替换时不删除
 */
-func (s *ConnManager) removeConn(cilentID string) {
+func (s *ConnManager) removeConn(conn *Conn) {
 
 	s.mapLock.Lock()
-	delete(s.connMap, cilentID)
+	if s.connMap[conn.clientID] == conn {
+		delete(s.connMap, conn.clientID)
+	}
 	s.mapLock.Unlock()
 
 	return
@@ -117,7 +130,7 @@ func (s *ConnManager) clearConn() {
 		delete(s.connMap, key)
 
 	}
-	zaplog.ZapLogger.Info("【清空所有链接】")
+	s.ofServer.logger.Info("【清空所有链接】")
 	s.mapLock.Unlock()
 
 	return
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"github.com/guihai/ghmqtt/utils"
+	"github.com/guihai/ghmqtt/utils/ghlog"
+	"github.com/guihai/ghmqtt/utils/ratelimit"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+新链接速率限制
+每个 IP 和全局各一个令牌桶，白名单网段不限制
+*/
+type ConnRate struct {
+	// 重新加载配置时替换白名单
+	lock sync.RWMutex
+
+	perIP  *ratelimit.Limiter
+	global *ratelimit.Limiter
+
+	// 白名单网段
+	allowlist []*net.IPNet
+
+	// 计数
+	passed       uint64
+	allowlisted  uint64
+	rejectIP     uint64
+	rejectGlobal uint64
+}
+
+func newConnRate(cfg *utils.ConnRateConfig, logger ghlog.Logger) *ConnRate {
+	r := &ConnRate{}
+	r.update(cfg, logger)
+
+	return r
+}
+
+/*
+使用新的配置，计数保留
+令牌桶保留，速率和突发数修改时更新已有的桶，已经用完令牌的 IP 重新加载后仍然受限
+*/
+func (s *ConnRate) update(cfg *utils.ConnRateConfig, logger ghlog.Logger) {
+
+	if cfg == nil {
+		cfg = &utils.ConnRateConfig{}
+	}
+
+	var allowlist []*net.IPNet
+	for _, cidr := range cfg.Allowlist {
+		if !strings.Contains(cidr, "/") {
+			// 单个 IP
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			logger.Warn("【配置错误】速率限制白名单格式错误", ghlog.String("cidr", cidr))
+			continue
+		}
+
+		allowlist = append(allowlist, ipNet)
+	}
+
+	s.lock.Lock()
+	if s.perIP == nil {
+		s.perIP = ratelimit.NewLimiter(cfg.PerIPRate, cfg.PerIPBurst)
+		s.global = ratelimit.NewLimiter(cfg.GlobalRate, cfg.GlobalBurst)
+	} else {
+		s.perIP.SetRate(cfg.PerIPRate, cfg.PerIPBurst)
+		s.global.SetRate(cfg.GlobalRate, cfg.GlobalBurst)
+	}
+	s.allowlist = allowlist
+	s.lock.Unlock()
+}
+
+/*
+检查新链接，超过限制返回 false 和原因
+unix socket 等没有 IP 的链接只使用全局限制
+*/
+func (s *ConnRate) check(remoteAddr string) (bool, string) {
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var ip net.IP
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = net.ParseIP(host)
+	}
+
+	if ip != nil {
+		for _, ipNet := range s.allowlist {
+			if ipNet.Contains(ip) {
+				atomic.AddUint64(&s.allowlisted, 1)
+				return true, ""
+			}
+		}
+
+		if !s.perIP.Allow(ip.String()) {
+			atomic.AddUint64(&s.rejectIP, 1)
+			return false, "超过 IP 新链接速率限制"
+		}
+	}
+
+	if !s.global.Allow("") {
+		atomic.AddUint64(&s.rejectGlobal, 1)
+		return false, "超过服务新链接速率限制"
+	}
+
+	atomic.AddUint64(&s.passed, 1)
+	return true, ""
+}
+
+/*
+速率限制计数
+*/
+func (s *ConnRate) getInfo() map[string]interface{} {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return map[string]interface{}{
+		"Passed":       atomic.LoadUint64(&s.passed),
+		"Allowlisted":  atomic.LoadUint64(&s.allowlisted),
+		"RejectIP":     atomic.LoadUint64(&s.rejectIP),
+		"RejectGlobal": atomic.LoadUint64(&s.rejectGlobal),
+		"TrackedIP":    s.perIP.Len(),
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/guihai/ghmqtt/utils"
+	"github.com/guihai/ghmqtt/utils/ghlog"
+)
+
+func TestConnRateReload(t *testing.T) {
+
+	cfg := &utils.ConnRateConfig{PerIPRate: 0.001, PerIPBurst: 1}
+	r := newConnRate(cfg, ghlog.Nop())
+
+	if ok, _ := r.check("1.2.3.4:1000"); !ok {
+		t.Fatal("第一个链接应该通过")
+	}
+	if ok, _ := r.check("1.2.3.4:1001"); ok {
+		t.Fatal("应该超过限制")
+	}
+
+	// 重新加载相同的配置，用完令牌的 IP 仍然受限
+	r.update(cfg.Clone(), ghlog.Nop())
+	if ok, _ := r.check("1.2.3.4:1002"); ok {
+		t.Fatal("重新加载后应该仍然受限")
+	}
+
+	// 修改 burst 后原来的桶不补满
+	r.update(&utils.ConnRateConfig{PerIPRate: 0.001, PerIPBurst: 2}, ghlog.Nop())
+	if ok, _ := r.check("1.2.3.4:1003"); ok {
+		t.Fatal("修改配置后应该仍然受限")
+	}
+	if ok, _ := r.check("5.6.7.8:1000"); !ok {
+		t.Fatal("其他 IP 应该通过")
+	}
+
+	// 加入白名单后不限制
+	r.update(&utils.ConnRateConfig{PerIPRate: 0.001, PerIPBurst: 2, Allowlist: []string{"1.2.3.4"}}, ghlog.Nop())
+	if ok, _ := r.check("1.2.3.4:1004"); !ok {
+		t.Fatal("白名单应该通过")
+	}
+}
@@ -0,0 +1,323 @@
+package server
+
+import (
+	"github.com/guihai/ghmqtt/utils"
+	"github.com/guihai/ghmqtt/utils/ghlog"
+	"sync/atomic"
+	"time"
+)
+
+/*
+链接写数据
+1，sendByte 放入发送队列，不阻塞，队列满时 QoS1，QoS2 的 PUBLISH 退回会话队列，其他报文按 WriteQueuePolicy 处理
+2，写协程阻塞等待队列，合并队列中已有的报文写入 bufio 后一次发送，会话在等待时通知会话继续发送
+3，每次发送设置写超时，超时关闭链接
+*/
+
+/*
+发送统计，所有链接共用
+*/
+type WriteStats struct {
+	// 丢弃的 QoS0 报文数
+	dropped uint64
+	// 暂存的报文数
+	spilled uint64
+	// 退回会话队列的 QoS1，QoS2 PUBLISH 数
+	requeued uint64
+	// 队列满断开的链接数
+	overflow uint64
+	// 超过客户端最大报文长度没有转发的 PUBLISH 数
+	tooLarge uint64
+}
+
+func newWriteStats() *WriteStats {
+	return &WriteStats{}
+}
+
+/*
+发送统计信息，Depth 是所有链接当前队列中的报文数
+*/
+func (s *WriteStats) getInfo(cfg *utils.GlobalObj, conns []*Conn) map[string]interface{} {
+
+	var depth, maxDepth int
+	for _, co := range conns {
+		l := co.queueLen()
+		depth += l
+		if l > maxDepth {
+			maxDepth = l
+		}
+	}
+
+	return map[string]interface{}{
+		"QueueSize": cfg.WriteQueueSize,
+		"Policy":    cfg.WriteQueuePolicy,
+		"Depth":     depth,
+		"MaxDepth":  maxDepth,
+		"Dropped":   atomic.LoadUint64(&s.dropped),
+		"Spilled":   atomic.LoadUint64(&s.spilled),
+		"Requeued":  atomic.LoadUint64(&s.requeued),
+		"Overflow":  atomic.LoadUint64(&s.overflow),
+		"TooLarge":  atomic.LoadUint64(&s.tooLarge),
+	}
+}
+
+func (s *Conn) write() {
+
+	defer s.ofServer.connWg.Done()
+
+	for {
+
+		select {
+		case <-s.ctx.Done(): // 上下文关闭了 退出方法
+			return
+		case data := <-s.writerBuffChan:
+			//有数据要写给客户端
+			if err := s.writeBatch(data); err != nil {
+				s.stop()
+				return
+			}
+			s.queueDrained()
+		case <-s.spillChan:
+			if err := s.writeBatch(nil); err != nil {
+				s.stop()
+				return
+			}
+			s.queueDrained()
+		case <-s.drainChan:
+			// 关闭链接，写完队列中剩余的数据，最后发送 DISCONNECT
+			if s.writeBatch(nil) == nil && len(s.lastPacket) > 0 {
+				s.bufWriter.Write(s.lastPacket)
+				s.bufWriter.Flush()
+			}
+			s.stop()
+			return
+		}
+	}
+
+}
+
+/*
+写入 first 和队列中已有的报文，然后一次发送
+*/
+func (s *Conn) writeBatch(first []byte) error {
+
+	s.netConn.SetWriteDeadline(time.Now().Add(s.ofServer.writeTimeout()))
+
+	if len(first) > 0 {
+		if _, err := s.bufWriter.Write(first); err != nil {
+			return err
+		}
+	}
+
+	// 合并队列中已有的报文
+	for more := true; more; {
+		select {
+		case data := <-s.writerBuffChan:
+			if _, err := s.bufWriter.Write(data); err != nil {
+				return err
+			}
+		default:
+			more = false
+		}
+	}
+
+	// 暂存的报文在队列之后
+	for _, data := range s.takeSpill() {
+		if _, err := s.bufWriter.Write(data); err != nil {
+			return err
+		}
+	}
+
+	err := s.bufWriter.Flush()
+	if err != nil {
+		s.getLogger().Warn("【发送失败】", ghlog.Err(err))
+	}
+
+	return err
+}
+
+/*
+写通道接收数据，不阻塞
+加入发送队列或者暂存时返回 true
+*/
+func (s *Conn) sendByte(by []byte) bool {
+	return s.writePacket(by) == nil
+}
+
+/*
+写通道接收数据，不阻塞
+队列满时 QoS1，QoS2 的 PUBLISH 返回 errQueueFull，由会话保存到会话队列，写协程发送后继续发送
+其他报文 drop 丢弃 QoS0 报文，spill 暂存，其他情况断开链接
+超过客户端最大报文长度的 PUBLISH 返回 errPacketTooLarge，没有加入发送队列返回 errSendFailed
+*/
+func (s *Conn) writePacket(by []byte) error {
+
+	// 确认收到的 QoS1，QoS2 消息
+	s.ackReceived(by)
+
+	// 转换成链接使用的协议版本，v3.1.1 去掉属性
+	by = s.codec.encode(by)
+	if len(by) == 0 {
+		return errSendFailed
+	}
+
+	// 超过客户端最大报文长度的 PUBLISH 不发送，当作已经发送
+	if s.maxPacketSize > 0 && uint32(len(by)) > s.maxPacketSize && isPublish(by) {
+		atomic.AddUint64(&s.ofServer.writeStats.tooLarge, 1)
+		return errPacketTooLarge
+	}
+
+	// 已经有暂存的报文，后面的报文也暂存，保证顺序
+	if atomic.LoadInt32(&s.spillLen) > 0 && !isQosPublish(by) && s.spill(by) {
+		return nil
+	}
+
+	select {
+	case s.writerBuffChan <- by:
+		return nil
+	case <-s.ctx.Done():
+		// 链接已经关闭，丢弃数据
+		return errSendFailed
+	case <-s.drainChan:
+		// 链接正在关闭，丢弃数据
+		return errSendFailed
+	default:
+	}
+
+	// 发送队列已满，QoS1，QoS2 的 PUBLISH 由会话保存，链接断开也不丢失
+	if isQosPublish(by) {
+		atomic.AddUint64(&s.ofServer.writeStats.requeued, 1)
+		atomic.StoreInt32(&s.queueWait, 1)
+		return errQueueFull
+	}
+
+	switch s.ofServer.getConfig().WriteQueuePolicy {
+	case utils.QueueDrop:
+		if isQos0Publish(by) {
+			atomic.AddUint64(&s.ofServer.writeStats.dropped, 1)
+			return errSendFailed
+		}
+	case utils.QueueSpill:
+		if s.spill(by) {
+			return nil
+		}
+	}
+
+	atomic.AddUint64(&s.ofServer.writeStats.overflow, 1)
+	s.getLogger().Warn("【发送队列已满】断开链接", ghlog.String("policy", s.ofServer.getConfig().WriteQueuePolicy))
+
+	s.shutdown(disconnectQuotaExceeded)
+
+	return errSendFailed
+}
+
+/*
+暂存报文，超过 WriteSpillSize 返回 false
+*/
+func (s *Conn) spill(by []byte) bool {
+
+	s.spillLock.Lock()
+	if uint32(len(s.spillList)) >= s.ofServer.getConfig().WriteSpillSize {
+		s.spillLock.Unlock()
+		return false
+	}
+	s.spillList = append(s.spillList, by)
+	atomic.StoreInt32(&s.spillLen, int32(len(s.spillList)))
+	s.spillLock.Unlock()
+
+	atomic.AddUint64(&s.ofServer.writeStats.spilled, 1)
+
+	// 通知写协程
+	select {
+	case s.spillChan <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+/*
+取出所有暂存的报文
+*/
+func (s *Conn) takeSpill() [][]byte {
+
+	if atomic.LoadInt32(&s.spillLen) == 0 {
+		return nil
+	}
+
+	s.spillLock.Lock()
+	list := s.spillList
+	s.spillList = nil
+	atomic.StoreInt32(&s.spillLen, 0)
+	s.spillLock.Unlock()
+
+	return list
+}
+
+/*
+发送队列是否还有空间，有暂存的报文时没有空间
+没有空间时写协程发送后通知会话继续发送
+*/
+func (s *Conn) queueHasRoom() bool {
+	if len(s.writerBuffChan) < cap(s.writerBuffChan) && atomic.LoadInt32(&s.spillLen) == 0 {
+		return true
+	}
+
+	atomic.StoreInt32(&s.queueWait, 1)
+
+	// 设置等待后再检查一次，写协程可能已经发送完
+	return len(s.writerBuffChan) < cap(s.writerBuffChan) && atomic.LoadInt32(&s.spillLen) == 0
+}
+
+/*
+写协程发送后，会话在等待时继续发送会话队列中的消息
+*/
+func (s *Conn) queueDrained() {
+	if atomic.CompareAndSwapInt32(&s.queueWait, 1, 0) && s.session != nil {
+		s.session.resume(s)
+	}
+}
+
+/*
+发送队列中的报文数
+*/
+func (s *Conn) queueLen() int {
+	return len(s.writerBuffChan) + int(atomic.LoadInt32(&s.spillLen))
+}
+
+/*
+是否是 PUBLISH 报文
+*/
+func isPublish(by []byte) bool {
+	return len(by) > 0 && by[0]&0xF0 == 0x30
+}
+
+/*
+是否是 QoS0 的 PUBLISH 报文
+*/
+func isQos0Publish(by []byte) bool {
+	return isPublish(by) && (by[0]>>1)&0x03 == 0
+}
+
+/*
+是否是 QoS1，QoS2 的 PUBLISH 报文
+*/
+func isQosPublish(by []byte) bool {
+	return isPublish(by) && (by[0]>>1)&0x03 > 0
+}
+
+/*
+关闭链接，v5 发送 DISCONNECT 后关闭，v3.1.1 直接关闭
+写通道中剩余的数据会先发送，DISCONNECT 不进入队列，队列满时也可以发送
+*/
+func (s *Conn) shutdown(reasonCode uint8) {
+	s.drainOnce.Do(func() {
+		by, err := newMqttDataPack().packDISCONNECT(reasonCode)
+		if err == nil && len(by) > 0 {
+			// v3.1.1 服务端不发送 DISCONNECT
+			s.lastPacket = s.codec.encode(by)
+		}
+
+		close(s.drainChan)
+	})
+}
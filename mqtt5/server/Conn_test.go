@@ -0,0 +1,259 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+	"github.com/guihai/ghmqtt/utils"
+)
+
+func TestConnCheckReceive(t *testing.T) {
+	ser := newTestServer()
+	ser.getConfig().ReceiveMaximum = 2
+	c := newTestConn(ser, "c1")
+	c.session = newTestSession(c)
+
+	pub := func(qos uint8, id uint16, dup bool) *proto.PUBLISHProtocol {
+		return &proto.PUBLISHProtocol{Qos: qos, MsgId: id, Dup: dup}
+	}
+
+	steps := []struct {
+		name string
+		p    *proto.PUBLISHProtocol
+		want bool
+	}{
+		{"QoS0 不计数", pub(proto.QoS0, 0, false), true},
+		{"新的标识符设置 DUP 也计数", pub(proto.QoS2, 1, true), true},
+		{"相同标识符不再计数", pub(proto.QoS2, 1, true), true},
+		{"QoS1", pub(proto.QoS1, 2, false), true},
+		{"超过接收最大数量", pub(proto.QoS2, 3, false), false},
+	}
+	for _, st := range steps {
+		if got := c.checkReceive(st.p); got != st.want {
+			t.Fatalf("%s: 想要 %v 收到 %v", st.name, st.want, got)
+		}
+	}
+
+	// 返回 PUBCOMP，PUBACK 后释放
+	c.receivedQos2(1)
+	c.receivedQos2(3)
+	c.received()
+	if !c.checkReceive(pub(proto.QoS2, 4, true)) || !c.checkReceive(pub(proto.QoS1, 5, false)) {
+		t.Fatal("释放后应该可以接收")
+	}
+	if c.checkReceive(pub(proto.QoS1, 6, false)) {
+		t.Fatal("应该超过接收最大数量")
+	}
+}
+
+func TestConnLimitTakeover(t *testing.T) {
+	ser := newTestServer()
+	cfg := ser.getConfig()
+	cfg.MaxConn = 2
+	cfg.MaxConnPerUser = 1
+	lim := ser.connLimit
+
+	a := newTestConn(ser, "c1")
+	b := newTestConn(ser, "c2")
+	if code, _ := lim.acquire(a, "u1", nil); code != limitOK {
+		t.Fatalf("a %d", code)
+	}
+	if code, _ := lim.acquire(b, "u2", nil); code != limitOK {
+		t.Fatalf("b %d", code)
+	}
+
+	steps := []struct {
+		name string
+		user string
+		old  *Conn
+		want uint8
+	}{
+		{"超过服务最大连接数", "u3", nil, limitBusy},
+		{"接管其他用户的链接，用户名额不转移", "u1", b, limitQuota},
+		{"接管相同用户的链接", "u1", a, limitOK},
+	}
+	var c *Conn
+	for _, st := range steps {
+		c = newTestConn(ser, "c1")
+		if code, _ := lim.acquire(c, st.user, st.old); code != st.want {
+			t.Fatalf("%s: 想要 %d 收到 %d", st.name, st.want, code)
+		}
+	}
+
+	if a.limited || !c.limited || lim.total != 2 || lim.users["u1"] != 1 {
+		t.Fatalf("名额没有转移 total %d users %v", lim.total, lim.users)
+	}
+
+	// 被接管的链接关闭时不再释放名额
+	lim.release(a)
+	lim.release(c)
+	lim.release(c)
+	if lim.total != 1 || lim.users["u1"] != 0 || lim.listeners["test"] != 1 {
+		t.Fatalf("total %d users %v listeners %v", lim.total, lim.users, lim.listeners)
+	}
+}
+
+func TestConnUpdateSessionExpiry(t *testing.T) {
+	ser := newTestServer()
+	ser.getConfig().MaxSessionExpiry = 60
+	c := newTestConn(ser, "c1")
+	c.session = newTestSession(c)
+	c.connectExpiry = 100
+
+	// 不超过服务端上限
+	if !c.updateSessionExpiry(100) || c.session.expiry != 60 {
+		t.Fatalf("expiry %d", c.session.expiry)
+	}
+	if !c.updateSessionExpiry(0) || c.session.expiry != 0 {
+		t.Fatalf("expiry %d", c.session.expiry)
+	}
+
+	// 按 CONNECT 中的值检查，会话中已经改为 0 时也可以修改
+	if !c.updateSessionExpiry(10) || c.session.expiry != 10 {
+		t.Fatalf("expiry %d", c.session.expiry)
+	}
+
+	// CONNECT 中为 0 时不能修改
+	c.connectExpiry = 0
+	c.session.expiry = 0
+	if c.updateSessionExpiry(10) || c.session.expiry != 0 {
+		t.Fatalf("expiry %d", c.session.expiry)
+	}
+	if !c.updateSessionExpiry(0) {
+		t.Fatal("改为 0 不是错误")
+	}
+}
+
+func TestConnSetKeepAlive(t *testing.T) {
+	ser := newTestServer()
+	ser.getConfig().MaxKeepAlive = 60
+
+	cases := []struct {
+		name       string
+		codec      codec
+		keepAlive  uint16
+		wantServer uint16
+		wantLive   time.Duration
+	}{
+		{"v5 不超过", v5Codec{}, 30, 0, 45 * time.Second},
+		{"v5 超过使用最大值", v5Codec{}, 120, 60, 90 * time.Second},
+		{"v5 为 0 使用最大值", v5Codec{}, 0, 60, 90 * time.Second},
+		{"3.1.1 超过使用客户端的值", v311Codec{}, 120, 0, 180 * time.Second},
+		{"3.1.1 为 0 不限制", v311Codec{}, 0, 0, 0},
+	}
+
+	for _, c := range cases {
+		co := newTestConn(ser, "c1")
+		co.codec = c.codec
+		if got := co.setKeepAlive(c.keepAlive); got != c.wantServer || co.liveTime != c.wantLive {
+			t.Fatalf("%s: 想要 %d %v 收到 %d %v", c.name, c.wantServer, c.wantLive, got, co.liveTime)
+		}
+	}
+}
+
+func TestConnWritePacketQueueFull(t *testing.T) {
+
+	qos0 := []byte{0x30, 0x03, 0x00, 0x01, 'a'}
+	qos1 := []byte{0x32, 0x05, 0x00, 0x01, 'a', 0x00, 0x01}
+	puback := []byte{0x40, 0x02, 0x00, 0x01}
+
+	cases := []struct {
+		name     string
+		policy   string
+		by       []byte
+		want     error
+		shutdown bool
+	}{
+		{"drop QoS0 丢弃", utils.QueueDrop, qos0, errSendFailed, false},
+		{"drop QoS1 退回会话", utils.QueueDrop, qos1, errQueueFull, false},
+		{"drop 其他报文断开", utils.QueueDrop, puback, errSendFailed, true},
+		{"disconnect QoS1 退回会话", utils.QueueDisconnect, qos1, errQueueFull, false},
+		{"disconnect QoS0 断开", utils.QueueDisconnect, qos0, errSendFailed, true},
+		{"spill QoS0 暂存", utils.QueueSpill, qos0, nil, false},
+		{"spill QoS1 退回会话", utils.QueueSpill, qos1, errQueueFull, false},
+	}
+
+	for _, c := range cases {
+		ser := newTestServer()
+		ser.getConfig().WriteQueueSize = 1
+		ser.getConfig().WriteQueuePolicy = c.policy
+		co := newTestConn(ser, "c1")
+		co.sendByte(puback)
+
+		if err := co.writePacket(c.by); err != c.want {
+			t.Fatalf("%s: 想要 %v 收到 %v", c.name, c.want, err)
+		}
+
+		select {
+		case <-co.drainChan:
+			if !c.shutdown {
+				t.Fatalf("%s: 不应该断开链接", c.name)
+			}
+		default:
+			if c.shutdown {
+				t.Fatalf("%s: 应该断开链接", c.name)
+			}
+		}
+	}
+}
+
+// 不使用 SendRES，直接写入发送队列返回确认的路由
+type rawAckRouter struct {
+	*BaseRouter
+	flag uint8
+}
+
+func (s *rawAckRouter) Handle(request *Request) {
+	var id uint16
+	switch p := request.GetProto().(type) {
+	case *proto.PUBLISHProtocol:
+		id = p.MsgId
+	case *proto.PUBRELProtocol:
+		id = uint16(p.PacketIdentifier[0])<<8 | uint16(p.PacketIdentifier[1])
+	}
+	request.getConn().sendByte([]byte{s.flag, 0x02, byte(id >> 8), byte(id)})
+}
+
+func TestConnAckReceivedWithoutSendRES(t *testing.T) {
+	ser := newTestServer()
+	ser.getConfig().ReceiveMaximum = 1
+	c := newTestConn(ser, "c1")
+	c.session = newTestSession(c)
+
+	ser.routerMer.addRouter(proto.PUBLISH32, &rawAckRouter{flag: proto.PUBACK})
+	ser.routerMer.addRouter(proto.PUBREL, &rawAckRouter{flag: proto.PUBCOMP})
+
+	handle := func(p proto.ImplMqttProto) {
+		req := newRequest(c)
+		req.proto = p
+		ser.routerMer.doRouterFunc(req)
+	}
+
+	// QoS1 路由返回 PUBACK 后释放
+	pub := &proto.PUBLISHProtocol{Fixed: &proto.Fixed{HeaderFlag: proto.PUBLISH32}, Qos: proto.QoS1, MsgId: 1}
+	if !c.checkReceive(pub) {
+		t.Fatal("第一个 QoS1")
+	}
+	handle(pub)
+	if !c.checkReceive(&proto.PUBLISHProtocol{Qos: proto.QoS2, MsgId: 2}) {
+		t.Fatal("PUBACK 后应该可以接收")
+	}
+
+	// QoS2 路由返回 PUBCOMP 后释放
+	handle(&proto.PUBRELProtocol{Fixed: &proto.Fixed{HeaderFlag: proto.PUBREL}, PacketIdentifier: [2]byte{0, 2}})
+	if !c.checkReceive(&proto.PUBLISHProtocol{Qos: proto.QoS2, MsgId: 3}) {
+		t.Fatal("PUBCOMP 后应该可以接收")
+	}
+
+	// 成功的 PUBREC 不释放，拒绝的 PUBREC 释放
+	c.sendByte([]byte{proto.PUBREC, 0x02, 0x00, 0x03})
+	if c.checkReceive(&proto.PUBLISHProtocol{Qos: proto.QoS2, MsgId: 4}) {
+		t.Fatal("成功的 PUBREC 不应该释放")
+	}
+	c.receivedQos2(4)
+	c.sendByte([]byte{proto.PUBREC, 0x03, 0x00, 0x03, 0x80})
+	if !c.checkReceive(&proto.PUBLISHProtocol{Qos: proto.QoS2, MsgId: 5}) {
+		t.Fatal("拒绝的 PUBREC 后应该可以接收")
+	}
+}
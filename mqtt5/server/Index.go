@@ -1,10 +1,14 @@
 package server
 
 import (
+	"context"
 	"github.com/guihai/ghmqtt/mqtt5/proto"
 	"github.com/guihai/ghmqtt/mqtt5/server/types"
 	"github.com/guihai/ghmqtt/utils"
-	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -17,20 +21,67 @@ type GHapi struct {
 	server *Server
 }
 
-func NewGHapi() *GHapi {
+/*
+创建服务，opts 设置配置，日志，存储等，没有设置的使用默认值
+*/
+func NewGHapi(opts ...Option) *GHapi {
 	return &GHapi{
-		server: newServer(),
+		server: newServer(opts...),
 	}
 }
 
-// 启动服务
-func (s *GHapi) Run() {
-	s.server.run()
+/*
+启动服务并阻塞，监听开启失败返回错误
+ctx 结束后关闭服务，返回关闭结果
+*/
+func (s *GHapi) Run(ctx context.Context) error {
+	return s.server.run(ctx)
+}
+
+/*
+启动服务，不阻塞
+所有监听开启后返回第一个监听的地址，监听开启失败返回错误
+*/
+func (s *GHapi) Start() (net.Addr, error) {
+	err := s.server.start()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.server.addr(), nil
+}
+
+/*
+所有监听开启后关闭此通道
+*/
+func (s *GHapi) Ready() <-chan struct{} {
+	return s.server.readyChan
+}
+
+/*
+服务关闭后关闭此通道
+*/
+func (s *GHapi) Done() <-chan struct{} {
+	return s.server.exitChan
+}
+
+/*
+停止服务，超时时间使用配置 ShutdownTimeout
+超时返回 context.DeadlineExceeded，剩余链接被强制关闭
+*/
+func (s *GHapi) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.server.getConfig().ShutdownTimeout)*time.Second)
+	defer cancel()
+
+	return s.Shutdown(ctx)
 }
 
-// 停止服务
-func (s *GHapi) Stop() {
-	s.server.start()
+/*
+停止服务，ctx 结束后强制关闭剩余链接
+返回时所有链接和协程池的协程都已经退出
+*/
+func (s *GHapi) Shutdown(ctx context.Context) error {
+	return s.server.stop(ctx)
 }
 
 /*
@@ -47,6 +98,56 @@ func (s *GHapi) SetConnectVerify(cvf ConnectVerifyFUNC) {
 	s.server.routerMer.setConnectVerify(cvf)
 }
 
+/*
+注册遗嘱保存方法，ShutdownWill 为 persist 时服务关闭会调用
+*/
+func (s *GHapi) SetWillPersist(wpf WillPersistFUNC) {
+	s.server.willPersist = wpf
+}
+
+/*
+注册生成 clientID 的方法，客户端 clientID 为空时调用
+生成的 clientID 需要唯一，已经有会话时会接管会话
+*/
+func (s *GHapi) SetClientIDFunc(cf ClientIDFUNC) {
+	s.server.clientIDFunc = cf
+}
+
+/*
+注册重新加载方法，重新加载配置时调用，用于重新加载 ACL 和链接验证数据
+*/
+func (s *GHapi) SetReloadFunc(rf ReloadFUNC) {
+	s.server.reloadFunc = rf
+}
+
+/*
+收到信号后重新加载配置，默认 SIGHUP，不阻塞
+ctx 结束或者服务关闭后停止
+*/
+func (s *GHapi) ReloadOnSignal(ctx context.Context, sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		defer signal.Stop(ch)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.server.exitChan:
+				return
+			case <-ch:
+				s.server.reload()
+			}
+		}
+	}()
+}
+
 // 对http服务和管理者客户端暴露的接口,返回值都是结构体
 func (s *GHapi) ServerInfo() *types.Response {
 	back := types.NewResponse()
@@ -55,10 +156,17 @@ func (s *GHapi) ServerInfo() *types.Response {
 	back.Msg = utils.MsgText(utils.RECODE_OK)
 
 	back.Data = map[string]interface{}{
-		"IP":   s.server.ip,
-		"Port": s.server.port,
+		"Name": s.server.name,
 		// 获取链接对象个数
 		"LenConn": s.server.connMer.getLen(),
+		// 每个监听的链接数
+		"Listeners": s.server.getListenerList(),
+		// 连接数限制和拒绝次数
+		"ConnLimit": s.server.connLimit.getInfo(),
+		// 发送队列
+		"WriteQueue": s.server.writeStats.getInfo(s.server.getConfig(), s.server.getConns()),
+		// 会话数，离线会话数和离线消息数
+		"Session": s.server.sessMer.getInfo(),
 	}
 
 	return back
@@ -66,71 +174,58 @@ func (s *GHapi) ServerInfo() *types.Response {
 
 /*
 管理服务端 直接发布信息，不能发布保留信息(保留信息使用接口  SetRetainMsg)
-创造协议 管理方发布的信息直接发布，不用进入 topicmananger 的协程池
+管理方发布的信息直接发布，不用进入 topicmananger 的协程池
+QoS 取 msg.Qos 和订阅中较小的
 */
 func (s *GHapi) SendPublish(msg *types.PublishMsg) *types.Response {
 	back := types.NewResponse()
 
-	//1 创建协议
-	// todo 48 测试
-	p := &proto.PUBLISHProtocol{
-		Fixed: &proto.Fixed{
-			HeaderFlag: proto.PUBLISH,
-			MsgLen:     0,
-			Data:       nil,
-		},
-		TopicNameLength:  uint16(len(msg.TopicName)),
-		TopicName:        msg.TopicName,
-		PacketIdentifier: [2]byte{},
-		Payload:          []byte(msg.TopicMsg),
-		Qos:              msg.Qos,
-		Retain:           msg.Retain,
-		MsgId:            0,
-	}
+	// 多个匹配条件发送
+	s.server.topicMer.tm.matchSend(newMessage(msg.TopicName, []byte(msg.TopicMsg), msg.Qos, msg.MessageExpiry))
+	back.Code = utils.RECODE_OK
+	back.Msg = utils.MsgText(utils.RECODE_OK)
 
-	// 完善协议
-	if p.Qos > proto.QoS0 {
-		// 需要标识符
-		rand.Seed(time.Now().Unix())
-		p.MsgId = uint16(rand.Intn(50000))
-		p.MsgLen = 2
+	return back
+}
 
-		if p.Qos == proto.QoS2 {
-			p.HeaderFlag = proto.PUBLISH34
+// 设置保留消息
+func (s *GHapi) SetRetainMsg(msg *types.PublishMsg) *types.Response {
+	back := types.NewResponse()
 
-		} else {
-			// Qos1 处理
-			p.HeaderFlag = proto.PUBLISH32
-		}
-	}
+	s.server.topicMer.setRetainMsg(msg.TopicName, []byte(msg.TopicMsg), msg.Qos, msg.MessageExpiry)
 
-	p.MsgLen = p.MsgLen + 1 + 1 + uint32(p.TopicNameLength) + uint32(len(p.Payload))
+	back.Code = utils.RECODE_OK
+	back.Msg = utils.MsgText(utils.RECODE_OK)
+	return back
+}
 
-	// 打包
-	by, err := p.Pack()
+/*
+重新加载配置文件，需要使用 WithConfigFile 创建服务
+可以在线修改的配置立即生效，已经建立的链接不断开，Data 中返回已经生效和需要重启的配置
+*/
+func (s *GHapi) Reload() *types.Response {
+	back := types.NewResponse()
 
+	res, err := s.server.reload()
 	if err != nil {
-		back.Code = 5001
+		back.Code = utils.RECODE_PARAMERR
 		back.Msg = err.Error()
-	} else {
-
-		// 3 多个匹配条件发送
-		s.server.topicMer.tm.matchSend(p.TopicName, by)
-		back.Code = utils.RECODE_OK
-		back.Msg = utils.MsgText(utils.RECODE_OK)
+		return back
 	}
 
+	back.Code = utils.RECODE_OK
+	back.Msg = utils.MsgText(utils.RECODE_OK)
+	back.Data = res
 	return back
 }
 
-// 设置保留消息
-func (s *GHapi) SetRetainMsg(msg *types.PublishMsg) *types.Response {
+// 获取新链接速率限制计数
+func (s *GHapi) GetConnRate() *types.Response {
 	back := types.NewResponse()
 
-	s.server.topicMer.setRetainMsg(msg.TopicName, []byte(msg.TopicMsg))
-
 	back.Code = utils.RECODE_OK
 	back.Msg = utils.MsgText(utils.RECODE_OK)
+	back.Data = s.server.connRate.getInfo()
 	return back
 }
 
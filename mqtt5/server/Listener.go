@@ -0,0 +1,347 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/guihai/ghmqtt/utils"
+	"github.com/guihai/ghmqtt/utils/ghlog"
+	"github.com/guihai/ghmqtt/utils/proxyproto"
+	"github.com/guihai/ghmqtt/utils/wsconn"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+监听对象，一个服务可以有多个监听
+tcp，tls，unix 使用 net.Listener，websocket 使用 http 服务
+*/
+type Listener struct {
+	// 监听配置 *utils.ListenerConfig，重新加载时整个替换
+	cfg atomic.Value
+	// tls 配置 *tls.Config，新链接握手时读取，重新加载证书不影响已经建立的链接
+	tlsCfg atomic.Value
+
+	// 所属服务
+	ofServer *Server
+
+	// 当前链接数
+	connCount int32
+
+	// 本监听的所有链接，包含还没有完成 CONNECT 的链接，服务关闭时使用
+	conns map[*Conn]struct{}
+	// 链接列表锁
+	connLock sync.Mutex
+	// 监听已关闭，不再接收链接
+	closed bool
+	// 接收链接的协程退出信号
+	serveDone chan struct{}
+
+	// tcp，tls，unix 监听
+	lis net.Listener
+	// websocket 使用的 http 服务
+	httpSer *http.Server
+}
+
+func newListener(cfg *utils.ListenerConfig, ser *Server) *Listener {
+	lis := &Listener{
+		ofServer: ser,
+
+		conns:     make(map[*Conn]struct{}),
+		serveDone: make(chan struct{}),
+	}
+	lis.cfg.Store(cfg)
+
+	return lis
+}
+
+/*
+开启监听
+1，绑定地址
+2，开启协程接收链接
+*/
+func (s *Listener) start() error {
+
+	lcfg := s.getConfig()
+
+	network := lcfg.Network
+	if lcfg.Type == utils.ListenerUnix {
+		network = "unix"
+		// 删除上次遗留的 socket 文件
+		os.Remove(lcfg.Address)
+	}
+	if network == "" {
+		network = "tcp"
+	}
+
+	lis, err := net.Listen(network, lcfg.Address)
+	if err != nil {
+		return err
+	}
+
+	// PROXY 头部在 tls 之前
+	if lcfg.ProxyProtocol {
+		lis = proxyproto.NewListener(lis, s.ofServer.connectTimeout())
+	}
+
+	if lcfg.IsTLS() {
+		if lcfg.TLS == nil {
+			lis.Close()
+			return errors.New("监听 " + lcfg.Name + " 没有 TLS 配置")
+		}
+
+		cfg, err := lcfg.TLS.NewTLSConfig()
+		if err != nil {
+			lis.Close()
+			return err
+		}
+		s.tlsCfg.Store(cfg)
+
+		// 每次握手使用最新的证书
+		lis = tls.NewListener(lis, &tls.Config{
+			GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return s.tlsCfg.Load().(*tls.Config), nil
+			},
+		})
+	}
+
+	s.lis = lis
+
+	s.ofServer.logger.Info("【监听开启成功】", ghlog.Listener(lcfg.Name),
+		ghlog.String("type", lcfg.Type), ghlog.String("address", lcfg.Address))
+
+	if lcfg.IsWebSocket() {
+		s.httpSer = &http.Server{}
+		go s.serveWebSocket()
+	} else {
+		go s.serve()
+	}
+
+	return nil
+}
+
+/*
+循环接收链接
+*/
+func (s *Listener) serve() {
+
+	defer close(s.serveDone)
+
+	for {
+		conn, err := s.lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				// 监听已关闭
+				return
+			}
+			s.ofServer.logger.Error("【错误】，接收连接错误", ghlog.Listener(s.getConfig().Name), ghlog.Err(err))
+			// 连接失败，继续下一个链接
+			continue
+		}
+
+		s.newConn(conn)
+	}
+}
+
+/*
+websocket 监听
+websocket 链接封装成 net.Conn 后和 tcp 链接使用相同的处理流程
+*/
+func (s *Listener) serveWebSocket() {
+
+	defer close(s.serveDone)
+
+	up := wsconn.NewUpgrader()
+
+	path := s.getConfig().Path
+	if path == "" {
+		path = "/mqtt"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		ws, err := wsconn.Upgrade(up, w, r)
+		if err != nil {
+			s.ofServer.logger.Error("【错误】，websocket 升级错误", ghlog.Listener(s.getConfig().Name),
+				ghlog.Addr(r.RemoteAddr), ghlog.Err(err))
+			return
+		}
+
+		s.newConn(ws)
+	})
+
+	s.httpSer.Handler = mux
+
+	err := s.httpSer.Serve(s.lis)
+	if err != nil && err != http.ErrServerClosed {
+		s.ofServer.logger.Error("【错误】，websocket 服务错误", ghlog.Listener(s.getConfig().Name), ghlog.Err(err))
+	}
+}
+
+/*
+封装链接对象，开启链接
+最大连接数在 CONNECT 时检查，超过时返回 CONNACK
+链接数在链接最终关闭时减少
+*/
+func (s *Listener) newConn(conn net.Conn) {
+
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+
+	if s.closed {
+		// 服务关闭中
+		conn.Close()
+		return
+	}
+
+	s.addCount()
+
+	// 封装链接对象
+	co := newConn(conn, s)
+	s.conns[co] = struct{}{}
+
+	s.ofServer.connWg.Add(1)
+	go co.start()
+}
+
+/*
+链接最终关闭时移出
+*/
+func (s *Listener) removeConn(co *Conn) {
+	s.connLock.Lock()
+	delete(s.conns, co)
+	s.connLock.Unlock()
+
+	s.doneCount()
+}
+
+/*
+本监听的所有链接
+*/
+func (s *Listener) getConns() []*Conn {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+
+	list := make([]*Conn, 0, len(s.conns))
+	for co := range s.conns {
+		list = append(list, co)
+	}
+
+	return list
+}
+
+/*
+关闭监听，不再接收新链接，等待接收协程退出
+已经建立的链接由服务关闭流程处理
+*/
+func (s *Listener) stop() {
+
+	s.connLock.Lock()
+	if s.closed {
+		s.connLock.Unlock()
+		return
+	}
+	s.closed = true
+	s.connLock.Unlock()
+
+	if s.lis == nil {
+		// 没有开启
+		return
+	}
+
+	if s.httpSer != nil {
+		// 关闭 http 服务，已经升级的 websocket 链接不受影响
+		s.httpSer.Close()
+	} else {
+		s.lis.Close()
+	}
+
+	<-s.serveDone
+
+	lcfg := s.getConfig()
+	if lcfg.Type == utils.ListenerUnix {
+		os.Remove(lcfg.Address)
+	}
+
+	s.ofServer.logger.Info("【监听关闭】", ghlog.Listener(lcfg.Name))
+}
+
+/*
+链接数 +1
+*/
+func (s *Listener) addCount() {
+	atomic.AddInt32(&s.connCount, 1)
+}
+
+/*
+链接数 -1
+*/
+func (s *Listener) doneCount() {
+	atomic.AddInt32(&s.connCount, -1)
+}
+
+/*
+当前链接数
+*/
+func (s *Listener) getLen() int {
+	return int(atomic.LoadInt32(&s.connCount))
+}
+
+/*
+监听地址，端口为 0 时可以获取实际端口
+*/
+func (s *Listener) addr() net.Addr {
+	if s.lis == nil {
+		return nil
+	}
+
+	return s.lis.Addr()
+}
+
+/*
+监听地址字符串，没有开启时使用配置地址
+*/
+func (s *Listener) getAddress() string {
+	if ad := s.addr(); ad != nil {
+		return ad.String()
+	}
+
+	return s.getConfig().Address
+}
+
+/*
+监听信息
+*/
+func (s *Listener) getInfo() map[string]interface{} {
+	lcfg := s.getConfig()
+
+	return map[string]interface{}{
+		"Name":    lcfg.Name,
+		"Type":    lcfg.Type,
+		"Address": s.getAddress(),
+		"MaxConn": lcfg.MaxConn,
+		"Proxy":   lcfg.ProxyProtocol,
+		"LenConn": s.getLen(),
+	}
+}
+
+/*
+监听配置，重新加载后返回新的配置
+*/
+func (s *Listener) getConfig() *utils.ListenerConfig {
+	return s.cfg.Load().(*utils.ListenerConfig)
+}
+
+/*
+重新加载时替换配置，tlsCfg 不为空时替换证书
+只影响新链接和之后的 CONNECT，已经建立的链接不受影响
+*/
+func (s *Listener) setConfig(cfg *utils.ListenerConfig, tlsCfg *tls.Config) {
+	s.cfg.Store(cfg)
+
+	if tlsCfg != nil {
+		s.tlsCfg.Store(tlsCfg)
+	}
+}
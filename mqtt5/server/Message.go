@@ -0,0 +1,129 @@
+package server
+
+import (
+	"time"
+
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+)
+
+/*
+发送给订阅者的消息
+每个订阅者使用自己的 QoS 和报文标识符打包
+*/
+type message struct {
+	topic   string
+	payload []byte
+	// 发送使用的 QoS
+	qos uint8
+	// 过期时间，零值不过期
+	expireAt time.Time
+	// 保留消息，发送时设置 RETAIN 标志
+	retain bool
+
+	// 转发给 v5 订阅者的 PUBLISH 属性
+	payloadFormat   uint8
+	contentType     string
+	responseTopic   string
+	correlationData string
+	userProperty    map[string]string
+}
+
+/*
+expiry 是 PUBLISH 中的消息过期间隔，秒，0 不过期
+*/
+func newMessage(topic string, payload []byte, qos uint8, expiry uint32) *message {
+	if qos > proto.QoS2 {
+		qos = proto.QoS2
+	}
+
+	return &message{
+		topic:    topic,
+		payload:  payload,
+		qos:      qos,
+		expireAt: expireTime(expiry),
+	}
+}
+
+/*
+客户端发布的消息，带上需要转发的属性
+*/
+func newPublishMessage(p *proto.PUBLISHProtocol) *message {
+	msg := newMessage(p.TopicName, p.Payload, p.Qos, p.MessageExpiryInterval)
+	msg.payloadFormat = p.PayloadFormatIndicator
+	msg.contentType = p.ContentType
+	msg.responseTopic = p.ResponseTopic
+	msg.correlationData = p.CorrelationData
+	msg.userProperty = p.UserProperty
+	return msg
+}
+
+/*
+遗嘱消息，带上遗嘱属性
+*/
+func newWillMessage(will *proto.Will) *message {
+	msg := newMessage(will.WillTopic, []byte(will.WillMessage), will.WillQos, will.MessageExpiryInterval)
+	msg.payloadFormat = will.PayloadFormatIndicator
+	msg.contentType = will.ContentType
+	msg.responseTopic = will.ResponseTopic
+	msg.correlationData = string(will.CorrelationData)
+	return msg
+}
+
+/*
+消息是否过期
+*/
+func (s *message) expired(now time.Time) bool {
+	_, ok := expiryLeft(s.expireAt, now)
+	return !ok
+}
+
+/*
+消息过期间隔转换成过期时间，0 不过期返回零值
+*/
+func expireTime(expiry uint32) time.Time {
+	if expiry == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(expiry) * time.Second)
+}
+
+/*
+剩余的消息过期间隔，秒，向上取整，转发时替换 PUBLISH 中的值
+不过期返回 0，已经过期返回 false
+*/
+func expiryLeft(expireAt time.Time, now time.Time) (uint32, bool) {
+	if expireAt.IsZero() {
+		return 0, true
+	}
+
+	left := expireAt.Sub(now)
+	if left <= 0 {
+		return 0, false
+	}
+
+	return uint32((left + time.Second - 1) / time.Second), true
+}
+
+/*
+按订阅的 QoS 复制消息，QoS 取发布和订阅中较小的
+*/
+func (s *message) withQos(qos uint8) *message {
+	if qos >= s.qos {
+		return s
+	}
+
+	m := *s
+	m.qos = qos
+	return &m
+}
+
+/*
+发送中的 QoS1，QoS2 消息，等待 PUBACK，PUBREC，PUBCOMP
+*/
+type inflight struct {
+	msg *message
+	// QoS2 已经收到 PUBREC，发送了 PUBREL，等待 PUBCOMP
+	released bool
+	// 重新链接后还没有重发
+	resend bool
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+)
+
+func TestPublishMessageProperties(t *testing.T) {
+
+	in := &proto.PUBLISHProtocol{
+		TopicName:              "req/a",
+		Payload:                []byte(`{"a":1}`),
+		Qos:                    proto.QoS1,
+		PayloadFormatIndicator: 1,
+		ContentType:            "application/json",
+		ResponseTopic:          "resp/a",
+		CorrelationData:        "id-1",
+		UserProperty:           map[string]string{"k": "v"},
+		TopicAlias:             3,
+		SubscriptionIdentifier: 9,
+	}
+
+	by, err := newMqttDataPack().packPUBLISH(newPublishMessage(in), 7, false, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flag, body, _ := splitPacket(by)
+	out := proto.NewPUBLISHProtocol(&proto.Fixed{HeaderFlag: flag, MsgLen: uint32(len(body)), Data: body})
+	if err := out.UnPack(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 发布者的属性转发给订阅者，主题别名和订阅标识符不转发
+	if out.PayloadFormatIndicator != 1 || out.ContentType != in.ContentType || out.ResponseTopic != in.ResponseTopic ||
+		out.CorrelationData != in.CorrelationData || !reflect.DeepEqual(out.UserProperty, in.UserProperty) {
+		t.Fatalf("属性没有转发 %+v", out)
+	}
+	if out.TopicAlias != 0 || out.SubscriptionIdentifier != 0 || out.MsgId != 7 || string(out.Payload) != `{"a":1}` {
+		t.Fatalf("%+v", out)
+	}
+
+	// 遗嘱属性
+	will := newWillMessage(&proto.Will{WillTopic: "w", WillMessage: "x", ContentType: "text/plain", CorrelationData: []byte("c")})
+	if will.contentType != "text/plain" || will.correlationData != "c" {
+		t.Fatalf("%+v", will)
+	}
+}
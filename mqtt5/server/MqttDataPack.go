@@ -5,8 +5,55 @@ import (
 	"errors"
 	"github.com/guihai/ghmqtt/mqtt5/proto"
 	"io"
+	"time"
 )
 
+// 服务端断开链接原因码
+const (
+	disconnectServerShutdown    = proto.Server_s_down
+	disconnectKeepAliveTimeout  = proto.Keep_Alive_to
+	disconnectQuotaExceeded     = proto.Quota_exceeded
+	disconnectPacketTooLarge    = proto.Packet_too_large
+	disconnectSessionTakenOver  = proto.Session_to
+	disconnectReceiveMaximum    = proto.Receive_M_e
+	disconnectTopicAliasInvalid = proto.Topic_A_i
+	disconnectProtocolError     = proto.Protocol_Error
+)
+
+// 服务端拒绝链接返回码
+const (
+	connackServerBusy    = proto.Server_busy
+	connackQuotaExceeded = proto.Quota_exceeded
+	connackRateExceeded  = proto.Connection_r_e
+	connackClientIDInUse = proto.ClientInotv
+)
+
+// 报文超过 MaxPacketSize，或者发送的 PUBLISH 超过客户端的 Maximum Packet Size
+var errPacketTooLarge = errors.New("报文超过最大长度")
+
+// 链接关闭或者发送队列已满，报文没有加入发送队列
+var errSendFailed = errors.New("报文没有加入发送队列")
+
+// 发送队列已满，QoS1，QoS2 的 PUBLISH 退回会话队列
+var errQueueFull = errors.New("发送队列已满")
+
+/*
+客户端 CONNECT 中的最大报文长度，0 不限制
+*/
+func clientMaxPacketSize(p *proto.CONNECTProtocol) uint32 {
+	return p.MaximumPacketSize
+}
+
+/*
+客户端 CONNECT 中的接收最大数量，没有设置时是 65535
+*/
+func clientReceiveMaximum(p *proto.CONNECTProtocol) uint16 {
+	if p.ReceiveMaximum == 0 {
+		return 0xFFFF
+	}
+	return p.ReceiveMaximum
+}
+
 /*
 先解包 固定报头，
 然后根据固定报头获取协议类型，再分别执行不同协议的解包方法
@@ -22,15 +69,111 @@ func newMqttDataPack() *MqttDataPack {
 打包， CONNACK 协议
 返回打包后的字节
 */
-func (s *MqttDataPack) packCONNACK(returncode uint8) ([]byte, error) {
+func (s *MqttDataPack) packCONNACK(ack *connAck) ([]byte, error) {
 
 	// 1，创建协议
-	p := proto.NewCONNACKProtocol(returncode)
+	p := proto.NewCONNACKProtocol(ack.code)
+	p.ReasonString = ack.reason
+	p.ServerKeepAlive = ack.serverKeepAlive
+	p.MaximumPacketSize = ack.maxPacketSize
+	p.ReceiveMaximum = ack.receiveMaximum
+	p.TopicAliasMaximum = ack.topicAliasMaximum
+	p.SessionExpiryInterval = ack.sessionExpiry
+	p.AssignedClientIdentifier = ack.assignedClientID
+	if ack.sessionPresent {
+		p.ConnectAcknowledgeFlags = 0x01
+	}
 
 	return p.Pack()
 
 }
 
+/*
+打包， 服务端 DISCONNECT 协议
+*/
+func (s *MqttDataPack) packDISCONNECT(reasonCode uint8) ([]byte, error) {
+
+	p := proto.NewDISCONNECTProtocolCode(reasonCode)
+
+	return p.Pack()
+}
+
+/*
+打包， 发送给订阅者的 PUBLISH 协议
+QoS1，QoS2 使用会话分配的报文标识符，重发时设置 DUP
+*/
+func (s *MqttDataPack) packPUBLISH(msg *message, id uint16, dup bool, alias uint16, aliasOnly bool) ([]byte, error) {
+
+	p := &proto.PUBLISHProtocol{
+		Fixed: &proto.Fixed{
+			HeaderFlag: proto.PUBLISH | msg.qos<<1,
+			MsgLen:     0,
+			Data:       nil,
+		},
+		TopicNameLength:  uint16(len(msg.topic)),
+		TopicName:        msg.topic,
+		PropertiesLength: 0, // 属性0
+		Payload:          msg.payload,
+		Qos:              msg.qos,
+
+		// 转发发布者的属性
+		PayloadFormatIndicator: msg.payloadFormat,
+		ContentType:            msg.contentType,
+		ResponseTopic:          msg.responseTopic,
+		CorrelationData:        msg.correlationData,
+		UserProperty:           msg.userProperty,
+	}
+
+	// 长度标识2 个 属性 1个
+	p.MsgLen = 2 + 1 + uint32(p.TopicNameLength) + uint32(len(p.Payload))
+
+	if msg.qos > proto.QoS0 {
+		// 报文标识符 2 个
+		p.MsgId = id
+		p.MsgLen += 2
+	}
+
+	if dup {
+		p.HeaderFlag |= 0x08
+	}
+
+	if msg.retain {
+		p.HeaderFlag |= 0x01
+		p.Retain = true
+	}
+
+	// 剩余的消息过期间隔
+	p.MessageExpiryInterval, _ = expiryLeft(msg.expireAt, time.Now())
+
+	// 主题别名，客户端已经知道别名时不发送主题
+	p.TopicAlias = alias
+	if aliasOnly {
+		p.TopicName = ""
+		p.TopicNameLength = 0
+	}
+
+	return p.Pack()
+}
+
+/*
+打包， 服务端 PUBREL 协议，QoS2 收到 PUBREC 后发送，重新链接后重发
+*/
+func (s *MqttDataPack) packPUBREL(id uint16) ([]byte, error) {
+
+	p := &proto.PUBRELProtocol{
+		Fixed: &proto.Fixed{
+			HeaderFlag: proto.PUBREL,
+			MsgLen:     4, // 标识符 2 + 原因码 1 + 属性 1
+			Data:       nil,
+		},
+		PacketIdentifier: [2]byte{byte(id >> 8), byte(id)},
+		MsgId:            id,
+		ReasonCode:       proto.Success,
+	}
+
+	return p.Pack()
+}
+
 /*
 解包固定 报头
 1,创建结构体
@@ -43,7 +186,7 @@ func (s *MqttDataPack) unPackFixed(conn *Conn) (*proto.Fixed, error) {
 	*/
 	HeaderFlag := make([]byte, 1)
 
-	tcpCon := conn.getTcpConn()
+	tcpCon := conn.getNetConn()
 
 	if _, err := io.ReadFull(tcpCon, HeaderFlag); err != nil {
 		return nil, errors.New("获取HeaderFlag失败" + err.Error())
@@ -106,10 +249,15 @@ func (s *MqttDataPack) unPackFixed(conn *Conn) (*proto.Fixed, error) {
 		return nil, errors.New("获取数据错误")
 	}
 
+	// 报文过长，分配内存前拒绝
+	if s.packetTooLarge(dataLen, conn.ofServer.getConfig().MaxPacketSize) {
+		return nil, errPacketTooLarge
+	}
+
 	data := make([]byte, dataLen)
 	if dataLen > 0 {
 		// 获取剩余字节数据
-		if _, err := io.ReadFull(conn.getTcpConn(), data); err != nil {
+		if _, err := io.ReadFull(tcpCon, data); err != nil {
 			return nil, errors.New("获取剩余 字节数据失败 " + err.Error())
 		}
 	}
@@ -135,6 +283,21 @@ func (s *MqttDataPack) unPackCONNECTProtocol(conn *Conn) (*proto.CONNECTProtocol
 	// 1，拆解固定报头
 	f, err := s.unPackFixed(conn)
 
+	if err == errPacketTooLarge {
+		return nil, proto.Packet_too_large
+	}
+	if err != nil {
+		return nil, proto.Malformed_Packet
+	}
+
+	// 根据协议级别选择编解码，v3.1.1 的 CONNECT 转换成 v5 报文后解析
+	cd, code := newCodec(f)
+	conn.codec = cd
+	if code != proto.Success {
+		return nil, code
+	}
+
+	f, err = cd.decode(f)
 	if err != nil {
 		return nil, proto.Malformed_Packet
 	}
@@ -143,10 +306,23 @@ func (s *MqttDataPack) unPackCONNECTProtocol(conn *Conn) (*proto.CONNECTProtocol
 	p := proto.NewCONNECTProtocol(f)
 
 	p.UnPack()
+	p.Version = cd.level()
 
 	return p, p.AckCode
 }
 
+/*
+报文总长度超过 max，0 不限制
+总长度包括固定报头
+*/
+func (s *MqttDataPack) packetTooLarge(dataLen, max uint32) bool {
+	if max == 0 {
+		return false
+	}
+
+	return uint64(1+len(s.msgLenCode(dataLen)))+uint64(dataLen) > uint64(max)
+}
+
 /*
 固定报头 剩余长度解码算法
 错误值返回 0
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"github.com/guihai/ghmqtt/utils"
+	"github.com/guihai/ghmqtt/utils/ghlog"
+	"github.com/guihai/ghmqtt/utils/zaplog"
+	"go.uber.org/zap"
+)
+
+/*
+服务配置选项 NewGHapi(opts ...Option)
+每个服务使用自己的配置，一个进程可以运行多个配置不同的服务
+WithConfig，WithConfigFile 替换整个配置，需要放在其他选项前面
+重新加载配置时按顺序再执行一次所有选项，其他选项设置的值不会被配置文件覆盖
+*/
+type Option func(*options)
+
+type options struct {
+	// 服务配置
+	cfg *utils.GlobalObj
+	// 日志
+	logger ghlog.Logger
+	// 保留消息存储
+	store RetainStore
+	// 日志级别，使用配置创建日志时可以修改
+	logLevel *zap.AtomicLevel
+
+	// 使用了配置文件，可以重新加载
+	fromFile bool
+	// 加载配置文件错误，启动和重新加载时返回
+	err error
+}
+
+/*
+执行所有选项，只设置配置
+*/
+func applyOptions(opts []Option) *options {
+	o := &options{
+		cfg: utils.DefaultConfig(),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+/*
+没有设置的选项使用默认值
+日志默认使用配置中的 LogCfg 创建
+*/
+func newOptions(opts []Option) *options {
+	o := applyOptions(opts)
+
+	if o.logger == nil {
+		logger, level := zaplog.NewLoggerLevel(o.cfg.LogCfg)
+		o.logger = zaplog.NewAdapter(logger)
+		o.logLevel = &level
+	}
+
+	if o.store == nil {
+		o.store = newMemoryStore()
+	}
+
+	return o
+}
+
+/*
+使用配置，一般来自 utils.LoadConfig，复制后使用，之后修改 cfg 不影响服务
+*/
+func WithConfig(cfg *utils.GlobalObj) Option {
+	return func(o *options) {
+		o.cfg = cfg.Clone()
+	}
+}
+
+/*
+使用配置文件，path 为空时只使用默认配置和环境变量，参考 utils.LoadConfig
+加载错误在启动时返回，GHapi.Reload 重新读取此文件
+*/
+func WithConfigFile(path string) Option {
+	return func(o *options) {
+		cfg, err := utils.LoadConfig(path)
+		if err != nil {
+			o.err = err
+			return
+		}
+
+		o.cfg = cfg
+		o.fromFile = true
+	}
+}
+
+/*
+服务名称
+*/
+func WithName(name string) Option {
+	return func(o *options) {
+		o.cfg.Name = name
+	}
+}
+
+/*
+监听列表，替换配置中的 Listeners，复制后使用
+*/
+func WithListeners(list ...*utils.ListenerConfig) Option {
+	return func(o *options) {
+		o.cfg.Listeners = make([]*utils.ListenerConfig, len(list))
+		for i, lc := range list {
+			o.cfg.Listeners[i] = lc.Clone()
+		}
+	}
+}
+
+/*
+服务最大连接数和每个用户名最大连接数，0 不限制
+*/
+func WithMaxConn(max, perUser uint32) Option {
+	return func(o *options) {
+		o.cfg.MaxConn = max
+		o.cfg.MaxConnPerUser = perUser
+	}
+}
+
+/*
+新链接速率限制
+*/
+func WithConnRate(rate *utils.ConnRateConfig) Option {
+	return func(o *options) {
+		o.cfg.ConnRate = rate.Clone()
+	}
+}
+
+/*
+报文最大长度，0 不限制
+*/
+func WithMaxPacketSize(size uint32) Option {
+	return func(o *options) {
+		o.cfg.MaxPacketSize = size
+	}
+}
+
+/*
+每个链接的发送队列长度和队列满时的处理方式
+*/
+func WithWriteQueue(size uint32, policy string) Option {
+	return func(o *options) {
+		o.cfg.WriteQueueSize = size
+		o.cfg.WriteQueuePolicy = policy
+	}
+}
+
+/*
+路由和 PUBLISH 协程池的工作者数量和每个工作者的队列长度
+*/
+func WithWorkPool(size, queueSize uint32) Option {
+	return func(o *options) {
+		o.cfg.WorkPoolSize = size
+		o.cfg.TaskQueueMaxSize = queueSize
+	}
+}
+
+/*
+日志，实现 ghlog.Logger 接口，可以适配 slog，zerolog 等
+不设置时使用配置 LogCfg 创建 zap 日志
+*/
+func WithLogger(logger ghlog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+/*
+使用 zap 日志
+*/
+func WithZapLogger(logger *zap.Logger) Option {
+	return WithLogger(zaplog.NewAdapter(logger))
+}
+
+/*
+保留消息存储，不设置时保存在内存中
+*/
+func WithStorage(store RetainStore) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
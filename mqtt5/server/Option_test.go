@@ -0,0 +1,40 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/guihai/ghmqtt/utils"
+	"github.com/guihai/ghmqtt/utils/ghlog"
+)
+
+func TestOptionsClone(t *testing.T) {
+
+	cfg := utils.DefaultConfig()
+	rate := &utils.ConnRateConfig{PerIPRate: 1, PerIPBurst: 2, Allowlist: []string{"10.0.0.0/8"}}
+	lis := &utils.ListenerConfig{Name: "a", Type: utils.ListenerTLS, Address: ":8883", TLS: &utils.TLSConfig{CertFile: "a.pem"}}
+
+	ser := newServer(WithConfig(cfg), WithConnRate(rate), WithListeners(lis), WithLogger(ghlog.Nop()))
+
+	// 修改传入的配置不影响服务
+	cfg.MaxConn = 1
+	rate.PerIPRate = 10
+	rate.Allowlist[0] = "0.0.0.0/0"
+	lis.Address = ":1"
+	lis.TLS.CertFile = "b.pem"
+
+	got := ser.getConfig()
+	if got.MaxConn != utils.DefaultConfig().MaxConn {
+		t.Fatalf("WithConfig MaxConn %d", got.MaxConn)
+	}
+	if got.ConnRate == rate || got.ConnRate.PerIPRate != 1 || got.ConnRate.Allowlist[0] != "10.0.0.0/8" {
+		t.Fatalf("WithConnRate %+v", got.ConnRate)
+	}
+	if len(got.Listeners) != 1 || got.Listeners[0] == lis || got.Listeners[0].Address != ":8883" || got.Listeners[0].TLS.CertFile != "a.pem" {
+		t.Fatalf("WithListeners %+v", got.Listeners[0])
+	}
+
+	// nil 不拷贝
+	if o := applyOptions([]Option{WithConnRate(nil), WithListeners(nil)}); o.cfg.ConnRate != nil || o.cfg.Listeners[0] != nil {
+		t.Fatal("nil 配置")
+	}
+}
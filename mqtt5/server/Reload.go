@@ -0,0 +1,246 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/guihai/ghmqtt/mqtt5/server/types"
+	"github.com/guihai/ghmqtt/utils"
+	"github.com/guihai/ghmqtt/utils/ghlog"
+	"go.uber.org/zap/zapcore"
+	"reflect"
+)
+
+/*
+重新加载配置
+1，再执行一次创建服务的选项，WithConfigFile 重新读取配置文件
+2，检查配置，加载 tls 证书，调用重新加载方法，有错误时不修改任何配置
+3，可以在线修改的配置立即生效，已经建立的链接不断开，之后的 CONNECT 和新链接使用新配置
+4，需要重启才能生效的配置保持原值，在结果中返回
+*/
+
+/*
+重新加载方法，配置检查通过后，生效之前调用，返回错误时取消重新加载
+用于重新加载 ACL，用户名密码等链接验证使用的数据
+*/
+type ReloadFUNC func(*utils.GlobalObj) error
+
+// 需要重启服务才能生效的配置
+var reloadRestartFields = map[string]bool{
+	"Name":             true,
+	"WorkPoolSize":     true,
+	"TaskQueueMaxSize": true,
+}
+
+// 生成监听列表的配置，按监听比较
+var reloadListenerFields = map[string]bool{
+	"IP":        true,
+	"Port":      true,
+	"Tcp":       true,
+	"TLS":       true,
+	"WebSocket": true,
+	"Listeners": true,
+}
+
+/*
+监听的新配置，检查通过后一起生效
+*/
+type reloadListener struct {
+	lis    *Listener
+	cfg    *utils.ListenerConfig
+	tlsCfg *tls.Config
+}
+
+func (s *Server) reload() (*types.ReloadResult, error) {
+
+	res, err := s.doReload()
+	if err != nil {
+		s.logger.Warn("【重新加载配置失败】", ghlog.Err(err))
+		return nil, err
+	}
+
+	s.logger.Info("【重新加载配置】", ghlog.Strings("applied", res.Applied), ghlog.Strings("restart", res.Restart))
+
+	return res, nil
+}
+
+func (s *Server) doReload() (*types.ReloadResult, error) {
+
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	o := applyOptions(s.opts)
+	if o.err != nil {
+		return nil, o.err
+	}
+	if !o.fromFile {
+		return nil, errors.New("没有使用 WithConfigFile，不能重新加载配置")
+	}
+	if err := o.cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	old := s.getConfig()
+	cfg := o.cfg
+
+	res := &types.ReloadResult{
+		Applied: []string{},
+		Restart: []string{},
+	}
+
+	lisList, lisRestart, err := s.reloadListeners(cfg, res)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.reloadFunc != nil {
+		if err := s.reloadFunc(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	ov := reflect.ValueOf(old).Elem()
+	nv := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < nv.NumField(); i++ {
+		name := nv.Type().Field(i).Name
+
+		if reloadListenerFields[name] {
+			// 有监听需要重启时保持原值，和正在运行的监听一致
+			if lisRestart {
+				nv.Field(i).Set(ov.Field(i))
+			}
+			continue
+		}
+
+		if reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+
+		switch {
+		case reloadRestartFields[name]:
+			res.Restart = append(res.Restart, name)
+			nv.Field(i).Set(ov.Field(i))
+		case name == "LogCfg":
+			s.reloadLog(old, cfg, res)
+		default:
+			res.Applied = append(res.Applied, name)
+		}
+	}
+
+	// 检查完成，开始生效
+	for _, item := range lisList {
+		item.lis.setConfig(item.cfg, item.tlsCfg)
+	}
+
+	if !reflect.DeepEqual(old.ConnRate, cfg.ConnRate) {
+		s.connRate.update(cfg.ConnRate, s.logger)
+	}
+
+	if s.logLevel != nil && cfg.LogCfg != nil {
+		s.logLevel.SetLevel(zapcore.Level(cfg.LogCfg.Level))
+	}
+
+	s.cfg.Store(cfg)
+
+	return res, nil
+}
+
+/*
+按名称比较监听
+地址，类型等改变和增加删除监听需要重启，最大连接数，用户名密码要求，证书可以在线修改
+tls 监听每次都重新读取证书文件，证书更新后文件路径不变也可以生效
+返回是否有监听需要重启
+*/
+func (s *Server) reloadListeners(cfg *utils.GlobalObj, res *types.ReloadResult) ([]reloadListener, bool, error) {
+
+	newList := cfg.GetListeners()
+	newMap := make(map[string]*utils.ListenerConfig, len(newList))
+	for _, lc := range newList {
+		newMap[lc.Name] = lc
+	}
+
+	restart := false
+	oldMap := make(map[string]bool, len(s.listeners))
+	list := make([]reloadListener, 0, len(s.listeners))
+
+	for _, lis := range s.listeners {
+		old := lis.getConfig()
+		oldMap[old.Name] = true
+
+		lc, ok := newMap[old.Name]
+		if !ok || !sameListener(old, lc) {
+			restart = true
+			res.Restart = append(res.Restart, "Listeners."+old.Name)
+			continue
+		}
+
+		item := reloadListener{lis: lis, cfg: lc}
+
+		if lc.IsTLS() {
+			tc, err := lc.TLS.NewTLSConfig()
+			if err != nil {
+				return nil, false, errors.New("监听 " + lc.Name + " " + err.Error())
+			}
+			item.tlsCfg = tc
+			res.Applied = append(res.Applied, "Listeners."+lc.Name+".TLS")
+		}
+
+		if old.MaxConn != lc.MaxConn {
+			res.Applied = append(res.Applied, "Listeners."+lc.Name+".MaxConn")
+		}
+		if old.RequireAuth != lc.RequireAuth {
+			res.Applied = append(res.Applied, "Listeners."+lc.Name+".RequireAuth")
+		}
+
+		list = append(list, item)
+	}
+
+	// 新增的监听
+	for _, lc := range newList {
+		if !oldMap[lc.Name] {
+			restart = true
+			res.Restart = append(res.Restart, "Listeners."+lc.Name)
+		}
+	}
+
+	return list, restart, nil
+}
+
+/*
+监听的地址和类型是否相同
+*/
+func sameListener(a, b *utils.ListenerConfig) bool {
+	return a.Type == b.Type && a.Network == b.Network && a.Address == b.Address &&
+		a.Path == b.Path && a.ProxyProtocol == b.ProxyProtocol
+}
+
+/*
+日志级别可以在线修改，使用 WithLogger 时级别由传入的日志控制
+日志文件等其他配置需要重启，保持原值
+*/
+func (s *Server) reloadLog(old, cfg *utils.GlobalObj, res *types.ReloadResult) {
+
+	if old.LogCfg == nil || cfg.LogCfg == nil {
+		res.Restart = append(res.Restart, "LogCfg")
+		cfg.LogCfg = old.LogCfg
+		return
+	}
+
+	lc := *old.LogCfg
+
+	if cfg.LogCfg.Level != old.LogCfg.Level {
+		if s.logLevel != nil {
+			lc.Level = cfg.LogCfg.Level
+			res.Applied = append(res.Applied, "LogCfg.Level")
+		} else {
+			res.Restart = append(res.Restart, "LogCfg.Level")
+		}
+	}
+
+	other := *cfg.LogCfg
+	other.Level = old.LogCfg.Level
+	if other != *old.LogCfg {
+		res.Restart = append(res.Restart, "LogCfg")
+	}
+
+	cfg.LogCfg = &lc
+}
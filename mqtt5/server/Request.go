@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/guihai/ghmqtt/mqtt5/proto"
+	"github.com/guihai/ghmqtt/utils/ghlog"
 )
 
 /*
@@ -16,6 +17,9 @@ type Request struct {
 
 	// 拆包工具
 	dp *MqttDataPack
+
+	// PUBACK，PUBREC，PUBCOMP 的报文标识符对应会话中发送中的消息
+	inflightFound bool
 }
 
 func newRequest(conn *Conn) *Request {
@@ -41,6 +45,11 @@ func (s *Request) getCONNECT() (*proto.CONNECTProtocol, uint8) {
 
 	p, code := s.dp.unPackCONNECTProtocol(s.ofConn)
 
+	if code == proto.Packet_too_large || code == proto.UnsupportedPV {
+		// 报文过长，不支持的协议级别，返回 CONNACK 后关闭链接
+		s.sendCONNACK(&connAck{code: code})
+	}
+
 	if code != proto.Success {
 		return nil, code
 	}
@@ -57,18 +66,16 @@ func (s *Request) getCONNECT() (*proto.CONNECTProtocol, uint8) {
 2,打包数据
 3，发送协议
 */
-func (s *Request) sendCONNACK(returncode uint8) error {
+func (s *Request) sendCONNACK(ack *connAck) error {
 
-	by, err := s.dp.packCONNACK(returncode)
+	by, err := s.dp.packCONNACK(ack)
 
 	if err != nil {
 		return err
 	}
 
-	// 发送数据
-	s.ofConn.sendByte(by)
-
-	return nil
+	// 写协程还没有开启，直接写入，拒绝链接时客户端也可以收到响应码
+	return s.ofConn.writeNow(s.ofConn.codec.encode(by))
 }
 
 /*
@@ -84,6 +91,12 @@ func (s *Request) getMqttProto() error {
 		return err
 	}
 
+	// v3.1.1 报文转换成 v5 报文
+	f, err = s.ofConn.codec.decode(f)
+	if err != nil {
+		return err
+	}
+
 	// 根据头部解析协议类型
 	p, err := s.dp.getProtoByFixed(f)
 
@@ -119,12 +132,25 @@ func (s *Request) SendRES(p proto.ImplMqttProto) error {
 
 // 优化后的 取消订阅方法路径
 func (s *Request) UnSubTopic(top string) {
+	s.ofConn.session.removeSub(top)
 	s.ofConn.ofServer.topicMer.unSubTopic(top, s.ofConn.clientID)
 }
 
-// 优化后的 订阅方法路径
+// 优化后的 订阅方法路径，QoS0
 func (s *Request) SubTopic(top string) {
-	s.ofConn.ofServer.topicMer.subTopic(top, s.ofConn.clientID)
+	s.SubTopicQos(top, proto.QoS0)
+}
+
+// 订阅主题，发送的消息 QoS 取发布和订阅中较小的，返回授予的 QoS 用于 SUBACK
+func (s *Request) SubTopicQos(top string, qos uint8) uint8 {
+	if qos > proto.QoS2 {
+		qos = proto.QoS2
+	}
+
+	s.ofConn.session.addSub(top)
+	s.ofConn.ofServer.topicMer.subTopic(top, s.ofConn.clientID, qos)
+
+	return qos
 }
 
 //  优化后的 发布方法路径
@@ -134,19 +160,49 @@ func (s *Request) SubTopic(top string) {
 
 // 优化后的  保存保留消息
 func (s *Request) SetRetainMsg(name string, payload []byte) {
-	s.ofConn.ofServer.topicMer.setRetainMsg(name, payload)
+	s.ofConn.ofServer.topicMer.setRetainMsg(name, payload, 0, 0)
+}
+
+// 保存保留消息的 QoS 和消息过期间隔，expiry 是 PUBLISH 中的消息过期间隔，秒，0 不过期
+func (s *Request) SetRetainMsgQos(name string, payload []byte, qos uint8, expiry uint32) {
+	s.ofConn.ofServer.topicMer.setRetainMsg(name, payload, qos, expiry)
 }
 
+// QoS2 报文标识符保存在会话中，重新链接后继续使用
 func (s *Request) GetQos2ID(id uint16) bool {
-	return s.ofConn.ofServer.topicMer.getQos2ID(id)
+	return s.ofConn.session.getQos2ID(id)
 }
 
 func (s *Request) SetQos2ID(id uint16) {
-	s.ofConn.ofServer.topicMer.setQos2ID(id)
+	s.ofConn.session.setQos2ID(id)
 }
 
 func (s *Request) RemoveQos2ID(id uint16) {
-	s.ofConn.ofServer.topicMer.removeQos2ID(id)
+	s.ofConn.session.removeQos2ID(id)
+}
+
+/*
+保存收到的 QoS2 消息，收到 PUBREL 后再转发，保证只转发一次
+返回 false 表示报文标识符已经存在，是重发的消息
+*/
+func (s *Request) StoreQos2Msg(sp *proto.PUBLISHProtocol) bool {
+	return s.ofConn.session.storeQos2(sp)
+}
+
+/*
+收到 PUBREL 时取出保存的 QoS2 消息
+返回 false 表示报文标识符不存在
+*/
+func (s *Request) ReleaseQos2Msg(id uint16) (*proto.PUBLISHProtocol, bool) {
+	return s.ofConn.session.releaseQos2(id)
+}
+
+/*
+收到的 PUBACK，PUBREC，PUBCOMP 是否对应发送中的消息
+PUBREC 路由在不对应时返回 PUBREL Packet_Inotf
+*/
+func (s *Request) InflightFound() bool {
+	return s.inflightFound
 }
 
 func (s *Request) MsgInPool(sp *proto.PUBLISHProtocol) {
@@ -163,3 +219,24 @@ func (s *Request) GetProto() proto.ImplMqttProto {
 func (s *Request) GetConnClientID() string {
 	return s.ofConn.clientID
 }
+
+// 获取链接信息 地址，证书身份
+func (s *Request) GetConnInfo() *ConnInfo {
+	return s.ofConn.info
+}
+
+/*
+请求的日志，带上客户标识符，客户端地址，监听名称和报文类型，PUBLISH 带上主题
+*/
+func (s *Request) Logger() ghlog.Logger {
+	if s.proto == nil {
+		return s.ofConn.getLogger()
+	}
+
+	fields := []ghlog.Field{ghlog.Packet(proto.PacketName(s.proto.GetHeaderFlag()))}
+	if p, ok := s.proto.(*proto.PUBLISHProtocol); ok {
+		fields = append(fields, ghlog.Topic(p.TopicName))
+	}
+
+	return s.ofConn.getLogger().With(fields...)
+}
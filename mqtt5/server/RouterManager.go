@@ -2,10 +2,9 @@ package server
 
 import (
 	"github.com/guihai/ghmqtt/mqtt5/proto"
-	"github.com/guihai/ghmqtt/utils"
-	"github.com/guihai/ghmqtt/utils/zaplog"
-	"go.uber.org/zap"
+	"github.com/guihai/ghmqtt/utils/ghlog"
 	"hash/fnv"
+	"sync"
 )
 
 /*
@@ -31,25 +30,36 @@ type RouterManager struct {
 
 	// WorkPoolIsOn
 	poolOn bool
+
+	// 关闭协程池信号
+	quitChan chan struct{}
+	quitOnce sync.Once
+	// 等待工作单位全部退出
+	wg sync.WaitGroup
+
+	// 所属服务
+	ofServer *Server
 }
 
-func newRouterManager() *RouterManager {
+func newRouterManager(ser *Server) *RouterManager {
 
 	r := &RouterManager{
+		ofServer: ser,
 
 		routerMap: make(map[uint8]ImplBaseRouter),
 
 		// 实现一个默认的ConnectVerifyFUNC
-		connectVerify: func(protocol *proto.CONNECTProtocol) uint8 {
+		connectVerify: func(protocol *proto.CONNECTProtocol, info *ConnInfo) uint8 {
 			return 0
 		},
 
 		// 创建协程池
-		workPoolSize: utils.GO.WorkPoolSize,
+		workPoolSize: ser.getConfig().WorkPoolSize,
 		//一个worker对应一个queue
-		taskQueue: make([]chan *Request, utils.GO.WorkPoolSize),
+		taskQueue: make([]chan *Request, ser.getConfig().WorkPoolSize),
 
-		poolOn: false, // 协程池未启动
+		poolOn:   false, // 协程池未启动
+		quitChan: make(chan struct{}),
 	}
 
 	// 实现默认的链接路由器
@@ -75,6 +85,8 @@ func newRouterManager() *RouterManager {
 
 	// 发布协议 52 路由
 	r.addRouter(proto.PUBLISH34, &PUBLISHRouter{})
+	// 发布协议 53 路由 有保留信息标志
+	r.addRouter(proto.PUBLISH35, &PUBLISHRouter{})
 
 	// 发布消息响应路由
 	r.addRouter(proto.PUBACK, &PUBACKRouter{})
@@ -118,9 +130,15 @@ func (s *RouterManager) addRouter(i uint8, router ImplBaseRouter) {
 
 func (s *RouterManager) doRouterFunc(request *Request) {
 
-	r, ok := s.routerMap[request.proto.GetHeaderFlag()]
+	flag := request.proto.GetHeaderFlag()
+	r, ok := s.routerMap[flag]
+	if !ok && flag&0xF0 == proto.PUBLISH && flag&0x08 != 0 {
+		// 重发的发布协议（DUP）使用去掉重发标志后的路由
+		r, ok = s.routerMap[flag&^0x08]
+	}
 	if !ok {
-		zaplog.ZapLogger.Warn("没有路由 协议 = ", zap.Uint8("协议编号", request.proto.GetHeaderFlag()))
+		request.getConn().getLogger().Warn("【没有路由】", ghlog.Packet(proto.PacketName(request.proto.GetHeaderFlag())),
+			ghlog.Uint8("flag", request.proto.GetHeaderFlag()))
 		return
 	}
 
@@ -148,9 +166,10 @@ func (s *RouterManager) startWorkerPool() {
 	for i := 0; i < int(s.workPoolSize); i++ {
 
 		// 初始化任务队列的管道
-		s.taskQueue[i] = make(chan *Request, utils.GO.TaskQueueMaxSize)
+		s.taskQueue[i] = make(chan *Request, s.ofServer.getConfig().TaskQueueMaxSize)
 
 		// 启动一个工作单位
+		s.wg.Add(1)
 		go s.startWorker(i, s.taskQueue[i])
 	}
 
@@ -161,17 +180,39 @@ func (s *RouterManager) startWorkerPool() {
 开启工作单位
 */
 func (s *RouterManager) startWorker(i int, requests chan *Request) {
-	zaplog.ZapLogger.Info("【路由管理者协程池开启】 创建工作者", zap.Int("编号", i))
+	s.ofServer.logger.Info("【路由管理者协程池开启】 创建工作者", ghlog.Int("编号", i))
+	defer s.wg.Done()
+
 	//不断的等待队列中的消息,然后进行路由处理
 	for {
 		select {
 		case re := <-requests:
 			s.doRouterFunc(re)
+		case <-s.quitChan:
+			// 处理完队列中剩余的任务再退出
+			for {
+				select {
+				case re := <-requests:
+					s.doRouterFunc(re)
+				default:
+					return
+				}
+			}
 		}
 	}
 
 }
 
+/*
+关闭协程池，等待队列中的任务处理完成
+*/
+func (s *RouterManager) stopWorkerPool() {
+	s.quitOnce.Do(func() {
+		close(s.quitChan)
+	})
+	s.wg.Wait()
+}
+
 //将消息交给TaskQueue,由worker进行处理
 func (s *RouterManager) sendReqToTaskQueue(request *Request) {
 	// 采用 链接id 取余数 放入对应的消息队列
@@ -1,24 +1,74 @@
 package server
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"github.com/guihai/ghmqtt/mqtt5/proto"
 	"github.com/guihai/ghmqtt/utils"
-	"github.com/guihai/ghmqtt/utils/zaplog"
+	"github.com/guihai/ghmqtt/utils/ghlog"
 	"go.uber.org/zap"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Server struct {
 	name string // 服务名称
-	ip   string // 监听ip 默认 0.0.0.0
-	port uint16 // 监听端口 默认  18883
-	tcp  string // 传输协议 默认 tcp4
+
+	// 服务配置 *utils.GlobalObj，重新加载时整个替换
+	cfg atomic.Value
+	// 创建服务的选项，重新加载配置时再执行一次
+	opts []Option
+	// 选项错误，启动时返回
+	optErr error
+	// 重新加载配置，同时只执行一次
+	reloadLock sync.Mutex
+
+	// 日志
+	logger ghlog.Logger
+	// 日志级别，使用 WithLogger 时为空
+	logLevel *zap.AtomicLevel
+	// 保留消息存储
+	store RetainStore
+
+	// 监听列表 所有监听共享链接管理器和主题管理器
+	listeners []*Listener
+
+	// 所有监听开启成功信号
+	readyChan chan struct{}
+	// 只启动一次
+	startOnce sync.Once
+	// 启动结果
+	startErr error
 
 	// 结束服务信号
-	exitChan chan bool
+	exitChan chan struct{}
+	// 只关闭一次
+	stopOnce sync.Once
+	// 关闭结果
+	stopErr error
+
+	// 所有链接协程，服务关闭时等待全部退出
+	connWg sync.WaitGroup
+
+	// 关闭服务时保存遗嘱的方法
+	willPersist WillPersistFUNC
+	// 重新加载配置时调用的方法
+	reloadFunc ReloadFUNC
+	// 生成 clientID 的方法
+	clientIDFunc ClientIDFUNC
 
 	// 链接对象管理器
 	connMer *ConnManager
+	// 会话管理器
+	sessMer *SessionManager
+	// 连接数限制
+	connLimit *ConnLimit
+	// 新链接速率限制
+	connRate *ConnRate
+	// 发送统计
+	writeStats *WriteStats
 
 	// 路由管理器
 	routerMer *RouterManager
@@ -27,74 +77,194 @@ type Server struct {
 	topicMer *TopicManager
 }
 
-func newServer() *Server {
+func newServer(opts ...Option) *Server {
+	o := newOptions(opts)
+
 	ser := &Server{
-		name:      utils.GO.Name,
-		ip:        utils.GO.IP,
-		port:      utils.GO.Port,
-		tcp:       utils.GO.Tcp,
-		connMer:   newConnManager(),
-		routerMer: newRouterManager(),
+		name:     o.cfg.Name,
+		opts:     opts,
+		optErr:   o.err,
+		logger:   o.logger,
+		logLevel: o.logLevel,
+		store:    o.store,
 
-		exitChan: make(chan bool),
+		writeStats: newWriteStats(),
+
+		readyChan: make(chan struct{}),
+		exitChan:  make(chan struct{}),
 	}
+	ser.cfg.Store(o.cfg)
+	ser.connMer = newConnManager(ser)
+	ser.sessMer = newSessionManager(ser)
+	ser.connLimit = newConnLimit(ser)
+	ser.connRate = newConnRate(o.cfg.ConnRate, o.logger)
+	ser.routerMer = newRouterManager(ser)
+
 	// 开启 自带的主题管理器标识
 	ser.topicMer = newTopicManager(ser)
 
+	// 创建监听
+	for _, cfg := range o.cfg.GetListeners() {
+		ser.listeners = append(ser.listeners, newListener(cfg, ser))
+	}
+
 	return ser
 }
 
 // 实现 接口方法
 /*
-启动tcp 服务
+启动服务 开启所有监听
+只执行一次，重复调用返回第一次的结果，服务关闭后返回错误
+*/
+func (s *Server) start() error {
+	s.startOnce.Do(func() {
+		s.startErr = s.doStart()
+	})
+
+	if s.startErr != nil {
+		return s.startErr
+	}
+
+	select {
+	case <-s.exitChan:
+		return errors.New("服务已经关闭")
+	default:
+	}
+
+	return nil
+}
+
+/*
+开启协程池和所有监听，监听绑定失败关闭服务并返回错误
 */
-func (s *Server) start() {
-	zaplog.ZapLogger.Info("【启动服务】" + s.name)
+func (s *Server) doStart() error {
+
+	// 配置错误不启动
+	if s.optErr != nil {
+		s.stop(context.Background())
+		return s.optErr
+	}
+	if err := s.getConfig().Validate(); err != nil {
+		s.stop(context.Background())
+		return err
+	}
+
+	s.logger.Info("【启动服务】" + s.name)
 
 	// 开启协程池，等待工作
 	s.routerMer.startWorkerPool()
 
-	// 开启协程监听
-	go func() {
-		// 0 创建地址
-		addr, err := net.ResolveTCPAddr(s.tcp, fmt.Sprintf("%s:%d", s.ip, s.port))
-		if err != nil {
-			zaplog.ZapLogger.Warn("【失败】获取tcp服务地址失败：" + err.Error())
-			return
-		}
-		// 1，启动监听
-		lis, err := net.ListenTCP(s.tcp, addr)
+	// 开启会话过期检查
+	s.sessMer.startSweeper()
+
+	for _, lis := range s.listeners {
+		err := lis.start()
 		if err != nil {
-			zaplog.ZapLogger.Warn("【失败】启动监听失败，" + err.Error())
+			s.logger.Warn("【失败】启动监听失败", ghlog.Listener(lis.getConfig().Name), ghlog.Err(err))
 
-			panic(err)
+			// 关闭已经开启的监听和协程池
+			s.stop(context.Background())
+
+			return errors.New("监听 " + lis.getConfig().Name + " 开启失败 " + err.Error())
 		}
+	}
 
-		zaplog.ZapLogger.Info("【服务开启成功】", zap.String("name", s.name), zap.Uint16("port", s.port))
+	close(s.readyChan)
 
-		// 2 开启循环接收链接
-		for {
-			conn, err := lis.AcceptTCP()
-			if err != nil {
-				zaplog.ZapLogger.Error("【错误】，接收连接错误" + err.Error())
-				// 连接失败，继续下一个链接
-				continue
-			}
+	s.logger.Info("【服务开启成功】", ghlog.String("name", s.name), ghlog.Int("listeners", len(s.listeners)))
 
-			// 封装链接对象
-			co := newConn(conn, s)
+	return nil
+}
 
-			go co.start()
-		}
-	}()
+/*
+监听信息列表
+*/
+func (s *Server) getListenerList() []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(s.listeners))
+
+	for _, lis := range s.listeners {
+		list = append(list, lis.getInfo())
+	}
 
+	return list
 }
 
-// 关闭服务
-// todo 关闭所有资源检查
-func (s *Server) stop() {
+/*
+关闭服务，ctx 超时后强制关闭剩余链接
+1，关闭所有监听，不再接收链接
+2，所有链接停止读取
+3，处理完路由和主题协程池队列中的任务
+4，按配置处理遗嘱，包括离线会话中的延迟遗嘱
+5，发送 DISCONNECT 服务端关闭中，写完队列中的数据后关闭链接
+6，等待所有协程退出
+*/
+func (s *Server) stop(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		s.stopErr = s.shutdown(ctx)
+
+		// 结束 run 阻塞
+		close(s.exitChan)
+	})
+
+	return s.stopErr
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
+
+	s.logger.Info("【服务开始关闭】")
+
+	for _, lis := range s.listeners {
+		lis.stop()
+	}
+
+	conns := s.getConns()
+	for _, co := range conns {
+		co.stopRead()
+	}
+
+	// 不再发送延迟遗嘱和删除过期会话
+	s.sessMer.stopSweeper()
+
+	// 协程池中剩余的任务还会发送数据给链接
+	waitCtx(ctx, s.routerMer.stopWorkerPool)
+	waitCtx(ctx, s.topicMer.tm.stopWorkerPool)
+
+	for _, co := range conns {
+		client := co.getClientID()
+		if client == "" {
+			continue
+		}
+
+		will, ok := s.topicMer.getClientWill(client)
+		s.topicMer.removeClientWill(client)
+		if ok {
+			s.shutdownWill(client, will)
+		}
+	}
+
+	for client, will := range s.sessMer.takeWills() {
+		s.shutdownWill(client, will)
+	}
+
+	for _, co := range s.getConns() {
+		co.shutdown(disconnectServerShutdown)
+	}
+
+	var err error
+	if !waitCtx(ctx, s.connWg.Wait) {
+		err = ctx.Err()
 
-	zaplog.ZapLogger.Info("【服务开始关闭】")
+		s.logger.Warn("【服务关闭超时】强制关闭剩余链接", ghlog.Int("conns", len(s.getConns())))
+
+		for _, co := range s.getConns() {
+			co.getNetConn().Close()
+		}
+	}
+
+	// 强制关闭后 协程都会退出
+	s.connWg.Wait()
+	s.routerMer.stopWorkerPool()
+	s.topicMer.tm.stopWorkerPool()
 
 	// 清理所有链接
 	s.connMer.clearConn()
@@ -102,26 +272,116 @@ func (s *Server) stop() {
 	// topic 关闭所有资源
 	s.topicMer.stop()
 
-	zaplog.ZapLogger.Info("【服务关闭】" + s.name + "停止服务，再见")
+	s.logger.Info("【服务关闭】" + s.name + "停止服务，再见")
+
+	return err
+}
+
+/*
+所有监听的链接
+*/
+func (s *Server) getConns() []*Conn {
+	var list []*Conn
+	for _, lis := range s.listeners {
+		list = append(list, lis.getConns()...)
+	}
+	return list
+}
 
-	// 关闭通道赋值
-	s.exitChan <- true
+/*
+服务关闭时处理遗嘱
+send 发送给订阅者，persist 交给保存方法，drop 丢弃
+调用前从链接或者会话中删除，链接关闭时不再发送
+*/
+func (s *Server) shutdownWill(client string, will *proto.Will) {
 
+	switch s.getConfig().ShutdownWill {
+	case utils.WillDrop:
+	case utils.WillPersist:
+		if s.willPersist == nil {
+			s.logger.Warn("【遗嘱丢弃】没有设置遗嘱保存方法", ghlog.Client(client))
+			break
+		}
+		s.willPersist(client, will)
+	default:
+		s.topicMer.sendWill(will)
+	}
 }
 
-// 实现 接口方法
-func (s *Server) run() {
+/*
+等待 fn 执行完成，ctx 先结束返回 false
+*/
+func waitCtx(ctx context.Context, fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
 
-	//调用start
-	s.start()
-	// 开启阻塞，
 	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+/*
+启动服务并阻塞
+ctx 结束后关闭服务，超时时间使用配置 ShutdownTimeout
+服务被其他地方关闭时也会返回
+*/
+func (s *Server) run(ctx context.Context) error {
+
+	err := s.start()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		sctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.getConfig().ShutdownTimeout)*time.Second)
+		defer cancel()
+
+		return s.stop(sctx)
 	case <-s.exitChan:
 		// 结束阻塞，关闭服务
-		return
+		return s.stopErr
 	}
 }
 
+/*
+服务配置，重新加载后返回新的配置，不要修改返回的配置
+*/
+func (s *Server) getConfig() *utils.GlobalObj {
+	return s.cfg.Load().(*utils.GlobalObj)
+}
+
+/*
+CONNECT 之前的超时时间，tls 握手，PROXY 头部，等待 CONNECT 使用
+*/
+func (s *Server) connectTimeout() time.Duration {
+	return time.Duration(s.getConfig().ConnLiveTime) * time.Second
+}
+
+/*
+写超时时间
+*/
+func (s *Server) writeTimeout() time.Duration {
+	return time.Duration(s.getConfig().WriteTimeout) * time.Second
+}
+
+/*
+第一个监听的地址
+*/
+func (s *Server) addr() net.Addr {
+	if len(s.listeners) < 1 {
+		return nil
+	}
+
+	return s.listeners[0].addr()
+}
+
 /*
 注册路由
 */
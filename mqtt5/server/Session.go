@@ -0,0 +1,786 @@
+package server
+
+import (
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+	"github.com/guihai/ghmqtt/utils/ghlog"
+	"sync"
+	"time"
+)
+
+/*
+会话，按 clientID 保存订阅，离线消息，发送中的消息和收到的 QoS2 报文标识符
+CleanStart=0 继续使用原来的会话，CONNACK 返回 Session Present，CleanStart=1 删除原来的会话
+链接断开后会话保留 Session Expiry Interval 秒，0 在链接断开时删除
+3.1.1 CleanSession=0 的会话不过期，都不超过配置 MaxSessionExpiry
+会话保存在内存中，服务重启后丢失
+*/
+
+// 会话不过期
+const sessionNeverExpire uint32 = 0xFFFFFFFF
+
+// 检查过期会话和延迟遗嘱的间隔
+const sessionSweepInterval = time.Second
+
+// 删除过期保留消息的间隔
+const retainSweepInterval = 10 * time.Second
+
+type Session struct {
+	clientID string
+
+	// 会话过期间隔，秒
+	expiry uint32
+	// 离线后的过期时间
+	expireAt time.Time
+
+	// 当前链接，离线时为空
+	conn *Conn
+
+	// 订阅的主题过滤器
+	subs map[string]struct{}
+
+	// 离线时收到的消息，最多 SessionQueueSize 条
+	queue []*message
+
+	// 发送中的 QoS1，QoS2 消息，报文标识符是 key，重新链接后按发送顺序重发
+	inflight    map[uint16]*inflight
+	inflightIDs []uint16
+	// 上一个分配的报文标识符
+	lastID uint16
+
+	// 收到的 QoS2 消息，报文标识符是 key，收到 PUBREL 后转发
+	qos2ID map[uint16]*proto.PUBLISHProtocol
+
+	// 延迟发送的遗嘱和发送时间，重新链接后取消
+	will   *proto.Will
+	willAt time.Time
+
+	lock sync.Mutex
+}
+
+func newSession(clientID string) *Session {
+	return &Session{
+		clientID: clientID,
+		subs:     make(map[string]struct{}),
+		qos2ID:   make(map[uint16]*proto.PUBLISHProtocol),
+		inflight: make(map[uint16]*inflight),
+	}
+}
+
+/*
+发送消息，离线时保存到队列，队列满时丢弃最早的消息
+*/
+func (s *Session) deliver(msg *message, max uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn != nil {
+		s.send(msg, max)
+		return
+	}
+
+	s.enqueue(msg, max)
+}
+
+/*
+离线消息，或者超过客户端接收最大数量的消息保存到队列，需要持有 lock
+*/
+func (s *Session) enqueue(msg *message, max uint32) {
+	if (s.conn == nil && s.expiry == 0) || max == 0 {
+		return
+	}
+
+	if uint32(len(s.queue)) >= max {
+		s.queue = s.queue[1:]
+	}
+	s.queue = append(s.queue, msg)
+}
+
+/*
+发送消息给当前链接，需要持有 lock
+QoS1，QoS2 分配报文标识符，加入发送队列后才加入发送中的消息
+发送中的消息达到客户端接收最大数量，或者没有可用的标识符时保存到队列
+超过客户端最大报文长度的消息丢弃，当作已经完成
+链接关闭或者发送队列已满时 QoS1，QoS2 保存到队列，发送队列有空间后继续发送，返回 false
+*/
+func (s *Session) send(msg *message, max uint32) bool {
+	var id uint16
+
+	// 过期的消息不再发送
+	if msg.expired(time.Now()) {
+		return true
+	}
+
+	if msg.qos > proto.QoS0 {
+		// 队列中有等待的消息时按顺序发送
+		if len(s.queue) > 0 || len(s.inflight) >= int(s.conn.receiveMaximum) {
+			s.enqueue(msg, max)
+			return true
+		}
+
+		var ok bool
+		if id, ok = s.allocID(); !ok {
+			s.enqueue(msg, max)
+			return true
+		}
+	}
+
+	switch err := s.conn.sendPublish(msg, id, false); err {
+	case nil:
+	case errPacketTooLarge:
+		// 客户端不能接收，丢弃
+		return true
+	default:
+		if msg.qos > proto.QoS0 {
+			s.enqueue(msg, max)
+		}
+		return false
+	}
+
+	if msg.qos > proto.QoS0 {
+		s.inflight[id] = &inflight{msg: msg}
+		s.inflightIDs = append(s.inflightIDs, id)
+	}
+
+	return true
+}
+
+/*
+分配报文标识符，跳过 0 和发送中的标识符，需要持有 lock
+分配后加入发送中的消息才占用标识符
+*/
+func (s *Session) allocID() (uint16, bool) {
+	if len(s.inflight) >= 0xFFFF {
+		return 0, false
+	}
+
+	for {
+		s.lastID++
+		if s.lastID == 0 {
+			continue
+		}
+		if _, ok := s.inflight[s.lastID]; !ok {
+			return s.lastID, true
+		}
+	}
+}
+
+/*
+重新链接时标记发送中的消息需要重发，需要持有 lock
+写协程开启后由 resume 发送
+*/
+func (s *Session) markResend() {
+	for _, fl := range s.inflight {
+		fl.resend = true
+	}
+}
+
+/*
+链接的写协程开启后，或者发送队列有空间后继续发送
+1，需要重发的 PUBLISH 设置 DUP 重发，已经收到 PUBREC 的重发 PUBREL
+2，发送离线消息
+发送队列没有空间时停止，剩下的留在会话中，写协程发送后再继续
+*/
+func (s *Session) resume(conn *Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn != conn {
+		return
+	}
+
+	dp := newMqttDataPack()
+	now := time.Now()
+
+	ids := append([]uint16(nil), s.inflightIDs...)
+	for _, id := range ids {
+		fl := s.inflight[id]
+		if !fl.resend {
+			continue
+		}
+
+		// 还没有收到 PUBREC 的过期消息不再重发
+		if !fl.released && fl.msg.expired(now) {
+			s.removeInflight(id)
+			continue
+		}
+
+		if !conn.queueHasRoom() {
+			return
+		}
+
+		if !fl.released {
+			switch conn.sendPublish(fl.msg, id, true) {
+			case nil:
+				fl.resend = false
+			case errPacketTooLarge:
+				// 超过客户端最大报文长度的消息丢弃
+				s.removeInflight(id)
+			default:
+				return
+			}
+			continue
+		}
+
+		by, err := dp.packPUBREL(id)
+		if err != nil {
+			continue
+		}
+
+		if !conn.sendByte(by) {
+			return
+		}
+		fl.resend = false
+	}
+
+	s.flush(conn.ofServer.getConfig().SessionQueueSize)
+}
+
+/*
+发送队列中的消息，需要持有 lock
+达到客户端接收最大数量，发送队列没有空间，或者发送失败时剩下的消息留在队列
+*/
+func (s *Session) flush(max uint32) {
+	queue := s.queue
+	s.queue = nil
+	for i, msg := range queue {
+		if (msg.qos > proto.QoS0 && len(s.inflight) >= int(s.conn.receiveMaximum)) || !s.conn.queueHasRoom() {
+			s.queue = append(s.queue, queue[i:]...)
+			return
+		}
+		if !s.send(msg, max) {
+			s.queue = append(s.queue, queue[i+1:]...)
+			return
+		}
+	}
+}
+
+/*
+收到订阅者的 PUBACK，PUBREC，PUBCOMP，更新发送中的消息
+1，PUBACK 完成 QoS1
+2，PUBREC 原因码小于 0x80 时等待 PUBCOMP，否则完成，重复的 PUBREC 需要再次返回 PUBREL
+3，PUBCOMP 完成 QoS2
+完成后发送队列中等待的消息
+报文标识符不存在或者状态不对时返回 false
+*/
+func (s *Session) ackInflight(flag uint8, id uint16, code uint8, max uint32) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fl, ok := s.inflight[id]
+	if !ok {
+		return false
+	}
+
+	switch flag {
+	case proto.PUBACK:
+		if fl.msg.qos != proto.QoS1 {
+			return false
+		}
+	case proto.PUBREC:
+		if fl.msg.qos != proto.QoS2 {
+			return false
+		}
+		if fl.released {
+			return code < 0x80
+		}
+		if code < 0x80 {
+			fl.released = true
+			return true
+		}
+	case proto.PUBCOMP:
+		if !fl.released {
+			return false
+		}
+	default:
+		return false
+	}
+
+	s.removeInflight(id)
+
+	if s.conn != nil {
+		s.flush(max)
+	}
+
+	return true
+}
+
+/*
+删除离线队列和发送中的过期消息，需要持有 lock
+已经收到 PUBREC 的消息需要等待 PUBCOMP，不删除
+*/
+func (s *Session) dropExpired(now time.Time) {
+	queue := s.queue[:0]
+	for _, msg := range s.queue {
+		if !msg.expired(now) {
+			queue = append(queue, msg)
+		}
+	}
+	for i := len(queue); i < len(s.queue); i++ {
+		s.queue[i] = nil
+	}
+	s.queue = queue
+
+	ids := append([]uint16(nil), s.inflightIDs...)
+	for _, id := range ids {
+		if fl := s.inflight[id]; !fl.released && fl.msg.expired(now) {
+			s.removeInflight(id)
+		}
+	}
+}
+
+/*
+删除发送中的消息，需要持有 lock
+*/
+func (s *Session) removeInflight(id uint16) {
+	delete(s.inflight, id)
+
+	for i, v := range s.inflightIDs {
+		if v == id {
+			s.inflightIDs = append(s.inflightIDs[:i], s.inflightIDs[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *Session) addSub(top string) {
+	s.lock.Lock()
+	s.subs[top] = struct{}{}
+	s.lock.Unlock()
+}
+
+func (s *Session) removeSub(top string) {
+	s.lock.Lock()
+	delete(s.subs, top)
+	s.lock.Unlock()
+}
+
+/*
+订阅列表
+*/
+func (s *Session) getSubs() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list := make([]string, 0, len(s.subs))
+	for top := range s.subs {
+		list = append(list, top)
+	}
+	return list
+}
+
+func (s *Session) setQos2ID(id uint16) {
+	s.lock.Lock()
+	if _, ok := s.qos2ID[id]; !ok {
+		s.qos2ID[id] = nil
+	}
+	s.lock.Unlock()
+}
+
+/*
+保存收到的 QoS2 消息，等待 PUBREL
+报文标识符已经存在时是重发的消息，返回 false，不能再次保存
+*/
+func (s *Session) storeQos2(sp *proto.PUBLISHProtocol) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.qos2ID[sp.MsgId]; ok {
+		return false
+	}
+	s.qos2ID[sp.MsgId] = sp
+	return true
+}
+
+/*
+收到 PUBREL，取出并移出 QoS2 消息
+报文标识符不存在返回 false
+*/
+func (s *Session) releaseQos2(id uint16) (*proto.PUBLISHProtocol, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sp, ok := s.qos2ID[id]
+	if ok {
+		delete(s.qos2ID, id)
+	}
+	return sp, ok
+}
+
+func (s *Session) getQos2ID(id uint16) bool {
+	s.lock.Lock()
+	_, ok := s.qos2ID[id]
+	s.lock.Unlock()
+
+	return ok
+}
+
+func (s *Session) removeQos2ID(id uint16) {
+	s.lock.Lock()
+	delete(s.qos2ID, id)
+	s.lock.Unlock()
+}
+
+/*
+替换会话的链接，返回原来的链接
+*/
+func (s *Session) swapConn(conn *Conn) *Conn {
+	s.lock.Lock()
+	old := s.conn
+	s.conn = conn
+	s.lock.Unlock()
+
+	return old
+}
+
+/*
+客户端 DISCONNECT 修改会话过期间隔
+会话已经被新链接接管时不修改
+*/
+func (s *Session) updateExpiry(conn *Conn, expiry uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn != conn {
+		return
+	}
+	s.expiry = expiry
+}
+
+/*
+取出延迟遗嘱
+*/
+func (s *Session) takeWill() *proto.Will {
+	s.lock.Lock()
+	will := s.will
+	s.will = nil
+	s.lock.Unlock()
+
+	return will
+}
+
+/*
+会话管理器
+*/
+type SessionManager struct {
+	// 会话 map clientID 是 key
+	sessMap map[string]*Session
+
+	// map 的读写锁
+	mapLock sync.RWMutex
+
+	// 关闭过期检查信号
+	quitChan chan struct{}
+	quitOnce sync.Once
+	// 等待过期检查退出
+	wg sync.WaitGroup
+
+	// 所属服务
+	ofServer *Server
+}
+
+func newSessionManager(ser *Server) *SessionManager {
+	return &SessionManager{
+		sessMap:  make(map[string]*Session),
+		quitChan: make(chan struct{}),
+		ofServer: ser,
+	}
+}
+
+/*
+会话过期间隔不超过配置 MaxSessionExpiry，返回使用的值和是否降低
+*/
+func (s *SessionManager) capExpiry(expiry uint32) (uint32, bool) {
+	max := s.ofServer.getConfig().MaxSessionExpiry
+	if max > 0 && expiry > max {
+		return max, true
+	}
+	return expiry, false
+}
+
+/*
+链接使用会话
+1，cleanStart 或者没有会话时创建新会话，删除原来的会话和订阅，原来会话的延迟遗嘱立即发送
+2，否则继续使用原来的会话，返回 present，取消延迟遗嘱，标记发送中的消息需要重发
+3，会话在线时由新链接接管，原来的链接断开
+链接的写协程还没有开启，重发和离线消息在 CONNACK 之后由 resume 发送
+*/
+func (s *SessionManager) open(conn *Conn, clientID string, cleanStart bool, expiry uint32) (*Session, bool) {
+
+	var will *proto.Will
+	var old *Conn
+
+	s.mapLock.Lock()
+
+	sess, ok := s.sessMap[clientID]
+	if ok && cleanStart {
+		old = sess.swapConn(nil)
+		will = s.discard(sess)
+		ok = false
+	}
+
+	if !ok {
+		sess = newSession(clientID)
+		s.sessMap[clientID] = sess
+	}
+
+	s.mapLock.Unlock()
+
+	if will != nil {
+		s.ofServer.topicMer.sendWill(will)
+	}
+
+	sess.lock.Lock()
+	if sess.conn != nil {
+		old = sess.conn
+	}
+	sess.expiry = expiry
+	sess.conn = conn
+	sess.will = nil
+	sess.markResend()
+	sess.lock.Unlock()
+
+	if old != nil {
+		s.takeover(old, clientID, cleanStart)
+	}
+
+	return sess, ok
+}
+
+/*
+会话被新链接接管，原来的链接停止读取，v5 收到 DISCONNECT 0x8E 后关闭
+原来链接的遗嘱立即发送，有遗嘱延迟间隔并且新链接继续使用会话时取消
+原来的链接已经不是会话的链接，关闭时不再处理会话和遗嘱
+*/
+func (s *SessionManager) takeover(old *Conn, clientID string, cleanStart bool) {
+
+	will, ok := s.ofServer.topicMer.getClientWill(clientID)
+	s.ofServer.topicMer.removeClientWill(clientID)
+
+	if ok && (will.WillDelayInterval == 0 || cleanStart) {
+		s.ofServer.topicMer.sendWill(will)
+	}
+
+	s.ofServer.logger.Info("【会话被接管】断开原来的链接", ghlog.Client(clientID), ghlog.Addr(old.info.RemoteAddr))
+
+	old.stopRead()
+	old.shutdown(disconnectSessionTakenOver)
+}
+
+/*
+链接关闭，会话离线
+1，取出链接的遗嘱，有遗嘱延迟间隔并且会话保留时保存到会话，延迟到期或者会话过期时发送，否则立即发送
+2，会话过期间隔为 0 时删除会话和订阅，否则记录过期时间
+会话已经被新链接使用时不处理
+*/
+func (s *SessionManager) close(conn *Conn) {
+
+	sess := conn.session
+	if sess == nil {
+		return
+	}
+
+	will, _ := s.ofServer.topicMer.getClientWill(sess.clientID)
+
+	now := time.Now()
+
+	sess.lock.Lock()
+	if sess.conn != conn {
+		sess.lock.Unlock()
+		return
+	}
+	s.ofServer.topicMer.removeClientWill(sess.clientID)
+
+	sess.conn = nil
+	expiry := sess.expiry
+	sess.expireAt = now.Add(time.Duration(expiry) * time.Second)
+	if will != nil && will.WillDelayInterval > 0 && expiry > 0 {
+		delay := will.WillDelayInterval
+		if delay > expiry {
+			delay = expiry
+		}
+		sess.will = will
+		sess.willAt = now.Add(time.Duration(delay) * time.Second)
+		will = nil
+	}
+	sess.lock.Unlock()
+
+	if will != nil {
+		s.ofServer.topicMer.sendWill(will)
+	}
+
+	if expiry == 0 {
+		s.remove(sess)
+	}
+}
+
+/*
+删除会话，会话已经被替换时不处理
+*/
+func (s *SessionManager) remove(sess *Session) {
+
+	var will *proto.Will
+
+	s.mapLock.Lock()
+	if s.sessMap[sess.clientID] == sess {
+		will = s.discard(sess)
+	}
+	s.mapLock.Unlock()
+
+	if will != nil {
+		s.ofServer.topicMer.sendWill(will)
+	}
+}
+
+/*
+删除会话和订阅，返回还没有发送的延迟遗嘱
+需要持有 mapLock，遗嘱在释放 mapLock 后发送
+*/
+func (s *SessionManager) discard(sess *Session) *proto.Will {
+
+	delete(s.sessMap, sess.clientID)
+
+	for _, top := range sess.getSubs() {
+		s.ofServer.topicMer.unSubTopic(top, sess.clientID)
+	}
+
+	return sess.takeWill()
+}
+
+/*
+根据 clientID 获取会话
+*/
+func (s *SessionManager) getSession(clientID string) (*Session, bool) {
+	s.mapLock.RLock()
+	sess, ok := s.sessMap[clientID]
+	s.mapLock.RUnlock()
+
+	return sess, ok
+}
+
+/*
+所有会话
+*/
+func (s *SessionManager) getSessions() []*Session {
+	s.mapLock.RLock()
+	list := make([]*Session, 0, len(s.sessMap))
+	for _, sess := range s.sessMap {
+		list = append(list, sess)
+	}
+	s.mapLock.RUnlock()
+
+	return list
+}
+
+/*
+开启过期检查
+*/
+func (s *SessionManager) startSweeper() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(sessionSweepInterval)
+		defer ticker.Stop()
+
+		retainTicker := time.NewTicker(retainSweepInterval)
+		defer retainTicker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				s.sweep(now)
+			case now := <-retainTicker.C:
+				s.ofServer.topicMer.removeExpiredRetain(now)
+			case <-s.quitChan:
+				return
+			}
+		}
+	}()
+}
+
+/*
+关闭过期检查
+*/
+func (s *SessionManager) stopSweeper() {
+	s.quitOnce.Do(func() {
+		close(s.quitChan)
+	})
+	s.wg.Wait()
+}
+
+/*
+离线会话到期的延迟遗嘱发送，过期的会话删除
+*/
+func (s *SessionManager) sweep(now time.Time) {
+
+	for _, sess := range s.getSessions() {
+
+		var will *proto.Will
+		expired := false
+
+		sess.lock.Lock()
+		if sess.conn == nil {
+			if sess.will != nil && !now.Before(sess.willAt) {
+				will = sess.will
+				sess.will = nil
+			}
+			expired = sess.expiry != sessionNeverExpire && !now.Before(sess.expireAt)
+			sess.dropExpired(now)
+		}
+		sess.lock.Unlock()
+
+		if will != nil {
+			s.ofServer.topicMer.sendWill(will)
+		}
+
+		if expired {
+			s.ofServer.logger.Debug("【会话过期】", ghlog.Client(sess.clientID))
+			s.remove(sess)
+		}
+	}
+}
+
+/*
+服务关闭，取出所有离线会话的延迟遗嘱
+*/
+func (s *SessionManager) takeWills() map[string]*proto.Will {
+
+	wills := make(map[string]*proto.Will)
+	for _, sess := range s.getSessions() {
+		if will := sess.takeWill(); will != nil {
+			wills[sess.clientID] = will
+		}
+	}
+
+	return wills
+}
+
+/*
+会话数，离线会话数，离线消息数，发送中的消息数和延迟遗嘱数
+*/
+func (s *SessionManager) getInfo() map[string]interface{} {
+
+	list := s.getSessions()
+
+	offline, queued, inflights, wills := 0, 0, 0, 0
+	for _, sess := range list {
+		sess.lock.Lock()
+		if sess.conn == nil {
+			offline++
+		}
+		if sess.will != nil {
+			wills++
+		}
+		queued += len(sess.queue)
+		inflights += len(sess.inflight)
+		sess.lock.Unlock()
+	}
+
+	return map[string]interface{}{
+		"Sessions": len(list),
+		"Offline":  offline,
+		"Queued":   queued,
+		"Inflight": inflights,
+		"Wills":    wills,
+	}
+}
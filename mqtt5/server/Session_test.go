@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+	"github.com/guihai/ghmqtt/utils"
+	"github.com/guihai/ghmqtt/utils/ghlog"
+)
+
+// 测试使用的服务，不启动监听
+func newTestServer() *Server {
+	return newServer(WithConfig(utils.DefaultConfig()), WithLogger(ghlog.Nop()))
+}
+
+// 测试使用的 v5 链接，写通道中的报文就是发送给客户端的数据
+func newTestConn(ser *Server, clientID string) *Conn {
+	a, _ := net.Pipe()
+	lis := newListener(&utils.ListenerConfig{Name: "test", Type: utils.ListenerTCP, Address: "127.0.0.1:0"}, ser)
+
+	c := newConn(a, lis)
+	c.clientID = clientID
+	c.receiveMaximum = 0xFFFF
+	c.aliasOut = newTopicAliases(0)
+	return c
+}
+
+// 在线的会话
+func newTestSession(c *Conn) *Session {
+	sess := newSession(c.clientID)
+	sess.expiry = 100
+	sess.conn = c
+	return sess
+}
+
+// 取出发送的报文，没有时返回 nil
+func nextPacket(c *Conn) []byte {
+	select {
+	case by := <-c.writerBuffChan:
+		return by
+	default:
+		return nil
+	}
+}
+
+// 发送的 PUBLISH 报文标识符
+func publishID(t *testing.T, by []byte) uint16 {
+	t.Helper()
+	if len(by) == 0 || by[0]&0xF0 != proto.PUBLISH {
+		t.Fatalf("不是 PUBLISH % x", by)
+	}
+	n := 2 + 2 + int(by[2])<<8 | int(by[3])
+	return uint16(by[n])<<8 | uint16(by[n+1])
+}
+
+func TestSessionSendTooLarge(t *testing.T) {
+	ser := newTestServer()
+	c := newTestConn(ser, "c1")
+	c.receiveMaximum = 1
+	c.maxPacketSize = 50
+	sess := newTestSession(c)
+
+	// 超过客户端最大报文长度，丢弃，不占用接收最大数量
+	sess.deliver(newMessage("a/b", bytes.Repeat([]byte{'x'}, 200), proto.QoS1, 0), 10)
+	if by := nextPacket(c); by != nil {
+		t.Fatalf("不应该发送 % x", by)
+	}
+	if len(sess.inflight) != 0 || len(sess.queue) != 0 {
+		t.Fatalf("inflight %d queue %d", len(sess.inflight), len(sess.queue))
+	}
+
+	sess.deliver(newMessage("a/b", []byte("small"), proto.QoS1, 0), 10)
+	by := nextPacket(c)
+	if by == nil {
+		t.Fatal("没有发送")
+	}
+	id := publishID(t, by)
+	if _, ok := sess.inflight[id]; !ok || len(sess.inflight) != 1 {
+		t.Fatalf("发送中的消息 %v", sess.inflightIDs)
+	}
+}
+
+func TestSessionSendFailed(t *testing.T) {
+	ser := newTestServer()
+	c := newTestConn(ser, "c1")
+	sess := newTestSession(c)
+
+	// 链接已经关闭，写通道不能写入
+	c.writerBuffChan = make(chan []byte)
+	c.cal()
+
+	sess.deliver(newMessage("a/b", []byte("m1"), proto.QoS1, 0), 10)
+	sess.deliver(newMessage("a/b", []byte("m2"), proto.QoS2, 0), 10)
+	if len(sess.inflight) != 0 || len(sess.queue) != 2 {
+		t.Fatalf("inflight %d queue %d", len(sess.inflight), len(sess.queue))
+	}
+
+	// 重新链接后按顺序发送
+	c2 := newTestConn(ser, "c1")
+	sess.lock.Lock()
+	sess.conn = c2
+	sess.lock.Unlock()
+	sess.resume(c2)
+
+	for _, want := range []string{"m1", "m2"} {
+		by := nextPacket(c2)
+		if !bytes.HasSuffix(by, []byte(want)) {
+			t.Fatalf("想要 %s 收到 % x", want, by)
+		}
+		if by[0]&0x08 != 0 {
+			t.Fatalf("第一次发送不应该有 DUP % x", by)
+		}
+	}
+	if len(sess.inflight) != 2 || len(sess.queue) != 0 {
+		t.Fatalf("inflight %d queue %d", len(sess.inflight), len(sess.queue))
+	}
+}
+
+func TestSessionAllocID(t *testing.T) {
+	sess := newSession("c1")
+
+	// 跳过发送中的标识符，回绕后跳过 0
+	msg := newMessage("a/b", []byte("m"), proto.QoS1, 0)
+	sess.lastID = 0xFFFE
+	sess.inflight[0xFFFF] = &inflight{msg: msg}
+	sess.inflight[1] = &inflight{msg: msg}
+
+	for _, want := range []uint16{2, 3} {
+		id, ok := sess.allocID()
+		if !ok || id != want {
+			t.Fatalf("想要 %d 收到 %d %v", want, id, ok)
+		}
+		sess.inflight[id] = &inflight{msg: msg}
+	}
+
+	// 完成后标识符可以重新使用
+	delete(sess.inflight, 0xFFFF)
+	sess.lastID = 0xFFFE
+	if id, ok := sess.allocID(); !ok || id != 0xFFFF {
+		t.Fatalf("想要 %d 收到 %d %v", 0xFFFF, id, ok)
+	}
+
+	// 标识符用完
+	for id := 1; id <= 0xFFFF; id++ {
+		sess.inflight[uint16(id)] = &inflight{msg: msg}
+	}
+	if _, ok := sess.allocID(); ok {
+		t.Fatal("标识符已经用完")
+	}
+}
+
+func TestSessionResumeDup(t *testing.T) {
+	ser := newTestServer()
+	c := newTestConn(ser, "c1")
+	sess := newTestSession(c)
+
+	sess.deliver(newMessage("a/b", []byte("m1"), proto.QoS1, 0), 10)
+	sess.deliver(newMessage("a/b", []byte("m2"), proto.QoS2, 0), 10)
+	id1 := publishID(t, nextPacket(c))
+	id2 := publishID(t, nextPacket(c))
+
+	// m2 收到 PUBREC，等待 PUBCOMP
+	if !sess.ackInflight(proto.PUBREC, id2, proto.Success, 10) {
+		t.Fatal("PUBREC")
+	}
+
+	// 链接断开后重新链接
+	sess.lock.Lock()
+	sess.conn = nil
+	sess.lock.Unlock()
+
+	c2 := newTestConn(ser, "c1")
+	sess.lock.Lock()
+	sess.conn = c2
+	sess.markResend()
+	sess.lock.Unlock()
+	sess.resume(c2)
+
+	// 没有确认的 PUBLISH 使用原来的标识符设置 DUP 重发
+	by := nextPacket(c2)
+	if by[0]&0x08 == 0 || publishID(t, by) != id1 || !bytes.HasSuffix(by, []byte("m1")) {
+		t.Fatalf("重发 % x", by)
+	}
+
+	// 已经收到 PUBREC 的重发 PUBREL
+	by = nextPacket(c2)
+	if len(by) < 4 || by[0] != proto.PUBREL || uint16(by[2])<<8|uint16(by[3]) != id2 {
+		t.Fatalf("PUBREL % x", by)
+	}
+
+	if by := nextPacket(c2); by != nil {
+		t.Fatalf("不应该再发送 % x", by)
+	}
+}
+
+func TestSessionResumeQueueFull(t *testing.T) {
+	ser := newTestServer()
+	ser.getConfig().WriteQueueSize = 3
+	c := newTestConn(ser, "c1")
+	sess := newTestSession(c)
+
+	sess.deliver(newMessage("a/b", []byte("d1"), proto.QoS1, 0), 10)
+	sess.deliver(newMessage("a/b", []byte("d2"), proto.QoS1, 0), 10)
+	nextPacket(c)
+	nextPacket(c)
+
+	// 离线后收到 3 条消息
+	sess.lock.Lock()
+	sess.conn = nil
+	sess.lock.Unlock()
+	for _, m := range []string{"q1", "q2", "q3"} {
+		sess.deliver(newMessage("a/b", []byte(m), proto.QoS1, 0), 10)
+	}
+
+	c2 := newTestConn(ser, "c1")
+	c2.session = sess
+	sess.lock.Lock()
+	sess.conn = c2
+	sess.markResend()
+	sess.lock.Unlock()
+
+	// 重发和离线消息超过发送队列长度，剩下的留在会话中，不断开链接
+	sess.resume(c2)
+	want := []string{"d1", "d2", "q1"}
+	if c2.queueLen() != len(want) || len(sess.queue) != 2 {
+		t.Fatalf("发送队列 %d 会话队列 %d", c2.queueLen(), len(sess.queue))
+	}
+	select {
+	case <-c2.drainChan:
+		t.Fatal("不应该断开链接")
+	default:
+	}
+
+	// 队列满时收到的消息也进入会话队列
+	sess.deliver(newMessage("a/b", []byte("q4"), proto.QoS1, 0), 10)
+	if len(sess.queue) != 3 {
+		t.Fatalf("会话队列 %d", len(sess.queue))
+	}
+
+	// 写协程发送后继续发送
+	for _, w := range [][]string{want, {"q2", "q3", "q4"}} {
+		for _, m := range w {
+			if by := nextPacket(c2); !bytes.HasSuffix(by, []byte(m)) {
+				t.Fatalf("想要 %s 收到 % x", m, by)
+			}
+		}
+		c2.queueDrained()
+	}
+	if len(sess.queue) != 0 || len(sess.inflight) != 6 {
+		t.Fatalf("inflight %d queue %d", len(sess.inflight), len(sess.queue))
+	}
+}
+
+func TestSessionQos2Flow(t *testing.T) {
+	ser := newTestServer()
+	c := newTestConn(ser, "c1")
+	c.receiveMaximum = 1
+	sess := newTestSession(c)
+
+	sess.deliver(newMessage("a/b", []byte("m1"), proto.QoS2, 0), 10)
+	sess.deliver(newMessage("a/b", []byte("m2"), proto.QoS2, 0), 10)
+	id := publishID(t, nextPacket(c))
+	if len(sess.queue) != 1 {
+		t.Fatalf("超过接收最大数量应该进入队列 %d", len(sess.queue))
+	}
+
+	steps := []struct {
+		name string
+		flag uint8
+		code uint8
+		want bool
+	}{
+		{"PUBREC 之前的 PUBCOMP", proto.PUBCOMP, proto.Success, false},
+		{"QoS2 的 PUBACK", proto.PUBACK, proto.Success, false},
+		{"PUBREC", proto.PUBREC, proto.Success, true},
+		{"重复的 PUBREC", proto.PUBREC, proto.Success, true},
+		{"PUBCOMP", proto.PUBCOMP, proto.Success, true},
+		{"重复的 PUBCOMP", proto.PUBCOMP, proto.Success, false},
+	}
+	for _, st := range steps {
+		if got := sess.ackInflight(st.flag, id, st.code, 10); got != st.want {
+			t.Fatalf("%s: 想要 %v 收到 %v", st.name, st.want, got)
+		}
+	}
+
+	// 完成后发送队列中的消息
+	by := nextPacket(c)
+	if !bytes.HasSuffix(by, []byte("m2")) || len(sess.queue) != 0 {
+		t.Fatalf("队列中的消息 % x", by)
+	}
+
+	// PUBREC 原因码大于等于 0x80 时完成，不等待 PUBCOMP
+	id = publishID(t, by)
+	if !sess.ackInflight(proto.PUBREC, id, 0x80, 10) || len(sess.inflight) != 0 {
+		t.Fatalf("发送中的消息 %d", len(sess.inflight))
+	}
+	if sess.ackInflight(proto.PUBCOMP, id, proto.Success, 10) {
+		t.Fatal("已经完成的标识符")
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+保留消息存储
+默认保存在内存中，可以使用 WithStorage 替换，例如保存到 redis 或者数据库，重启后保留消息不丢失
+*/
+type RetainStore interface {
+	// 保存保留消息，每个主题只保存一条，payload 为空时删除
+	SetRetain(topic string, payload []byte) error
+	// 获取保留消息
+	GetRetain(topic string) ([]byte, bool, error)
+}
+
+/*
+保存 QoS 和过期时间的保留消息存储，可选
+WithStorage 的存储同时实现这个接口时使用这里的方法，否则保留消息按 QoS0 发送，不过期
+*/
+type RetainMsgStore interface {
+	// 保存保留消息，每个主题只保存一条，Payload 为空时删除
+	SetRetainMsg(topic string, msg *RetainMsg) error
+	// 获取保留消息，过期的消息由服务端删除
+	GetRetainMsg(topic string) (*RetainMsg, bool, error)
+	// 删除 now 之前过期的保留消息，服务端定时调用，返回删除的数量
+	RemoveExpired(now time.Time) (int, error)
+}
+
+/*
+保留消息
+*/
+type RetainMsg struct {
+	Payload []byte
+	// 发布时的 QoS，发送时取和订阅中较小的
+	Qos uint8
+	// 过期时间，零值不过期
+	ExpireAt time.Time
+}
+
+/*
+内存存储
+*/
+type memoryStore struct {
+	// 保留消息map
+	retainMsg map[string]*RetainMsg
+	// 保留消息锁
+	retainLock sync.RWMutex
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		retainMsg: make(map[string]*RetainMsg),
+	}
+}
+
+func (s *memoryStore) SetRetain(topic string, payload []byte) error {
+	return s.SetRetainMsg(topic, &RetainMsg{Payload: payload})
+}
+
+func (s *memoryStore) GetRetain(topic string) ([]byte, bool, error) {
+	msg, ok, err := s.GetRetainMsg(topic)
+	if !ok {
+		return nil, ok, err
+	}
+	return msg.Payload, ok, err
+}
+
+func (s *memoryStore) SetRetainMsg(topic string, msg *RetainMsg) error {
+	s.retainLock.Lock()
+	defer s.retainLock.Unlock()
+
+	if len(msg.Payload) < 1 {
+		delete(s.retainMsg, topic)
+		return nil
+	}
+
+	s.retainMsg[topic] = msg
+	return nil
+}
+
+func (s *memoryStore) GetRetainMsg(topic string) (*RetainMsg, bool, error) {
+	s.retainLock.RLock()
+	defer s.retainLock.RUnlock()
+
+	msg, ok := s.retainMsg[topic]
+	return msg, ok, nil
+}
+
+func (s *memoryStore) RemoveExpired(now time.Time) (int, error) {
+	s.retainLock.Lock()
+	defer s.retainLock.Unlock()
+
+	n := 0
+	for topic, msg := range s.retainMsg {
+		if !msg.ExpireAt.IsZero() && !now.Before(msg.ExpireAt) {
+			delete(s.retainMsg, topic)
+			n++
+		}
+	}
+
+	return n, nil
+}
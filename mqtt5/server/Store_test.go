@@ -0,0 +1,102 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+)
+
+// 只实现 RetainStore 的存储
+type payloadStore struct {
+	msgs map[string][]byte
+}
+
+func (s *payloadStore) SetRetain(topic string, payload []byte) error {
+	s.msgs[topic] = payload
+	return nil
+}
+
+func (s *payloadStore) GetRetain(topic string) ([]byte, bool, error) {
+	by, ok := s.msgs[topic]
+	return by, ok, nil
+}
+
+func TestMemoryStoreRemoveExpired(t *testing.T) {
+	s := newMemoryStore()
+	now := time.Now()
+
+	s.SetRetainMsg("a", &RetainMsg{Payload: []byte("1"), ExpireAt: now.Add(-time.Second)})
+	s.SetRetainMsg("b", &RetainMsg{Payload: []byte("2"), ExpireAt: now.Add(time.Minute)})
+	s.SetRetain("c", []byte("3"))
+
+	if n, _ := s.RemoveExpired(now); n != 1 {
+		t.Fatalf("删除 %d", n)
+	}
+	if _, ok, _ := s.GetRetain("a"); ok {
+		t.Fatal("过期的消息没有删除")
+	}
+	if by, ok, _ := s.GetRetain("c"); !ok || string(by) != "3" {
+		t.Fatal("不过期的消息被删除")
+	}
+
+	// 空消息删除
+	s.SetRetain("c", nil)
+	if _, ok, _ := s.GetRetainMsg("c"); ok {
+		t.Fatal("没有删除")
+	}
+}
+
+func TestSendRetainMsg(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		store   RetainStore
+		pubQos  uint8
+		subQos  uint8
+		wantQos uint8
+	}{
+		{"QoS 取订阅中较小的", newMemoryStore(), proto.QoS2, proto.QoS1, proto.QoS1},
+		{"QoS 取保留消息中较小的", newMemoryStore(), proto.QoS1, proto.QoS2, proto.QoS1},
+		{"只实现 RetainStore 按 QoS0 发送", &payloadStore{msgs: make(map[string][]byte)}, proto.QoS2, proto.QoS2, proto.QoS0},
+	}
+
+	for _, c := range cases {
+		ser := newTestServer()
+		ser.store = c.store
+		co := newTestConn(ser, "c1")
+		sess := newTestSession(co)
+		ser.sessMer.sessMap["c1"] = sess
+
+		ser.topicMer.setRetainMsg("a/b", []byte("r"), c.pubQos, 60)
+		ser.topicMer.sendRetainMsg("a/b", "c1", c.subQos)
+
+		by := nextPacket(co)
+		if by == nil || by[0]&0x01 == 0 {
+			t.Fatalf("%s: 应该设置 RETAIN % x", c.name, by)
+		}
+		if qos := (by[0] >> 1) & 0x03; qos != c.wantQos {
+			t.Fatalf("%s: 想要 QoS%d 收到 % x", c.name, c.wantQos, by)
+		}
+
+		// QoS1，QoS2 使用会话的报文标识符
+		if c.wantQos > proto.QoS0 {
+			if _, ok := sess.inflight[publishID(t, by)]; !ok {
+				t.Fatalf("%s: 没有保存到发送中的消息", c.name)
+			}
+		}
+	}
+
+	// 过期的保留消息不发送
+	ser := newTestServer()
+	co := newTestConn(ser, "c1")
+	ser.sessMer.sessMap["c1"] = newTestSession(co)
+	ser.store.(*memoryStore).SetRetainMsg("a/b", &RetainMsg{Payload: []byte("r"), ExpireAt: time.Now().Add(-time.Second)})
+	ser.topicMer.sendRetainMsg("a/b", "c1", proto.QoS1)
+	if by := nextPacket(co); by != nil {
+		t.Fatalf("不应该发送 % x", by)
+	}
+	if _, ok, _ := ser.store.GetRetain("a/b"); ok {
+		t.Fatal("过期的消息没有删除")
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+)
+
+// 发送过一次的主题最多记录的数量，超过后重新记录
+const topicAliasSeenSize = 1000
+
+/*
+发送给客户端的主题别名，每个链接一个
+主题第二次发送时分配别名，别名用完后不再分配，链接断开后失效
+*/
+type topicAliases struct {
+	// 客户端 CONNECT 中的主题别名最大值，0 不使用别名
+	max uint16
+	// 主题和别名
+	aliases map[string]uint16
+	// 发送过一次，还没有分配别名的主题
+	seen map[string]struct{}
+
+	lock sync.Mutex
+}
+
+func newTopicAliases(max uint16) *topicAliases {
+	return &topicAliases{
+		max:     max,
+		aliases: make(map[string]uint16),
+		seen:    make(map[string]struct{}),
+	}
+}
+
+/*
+获取主题的别名，需要持有 lock
+返回 0 不使用别名，known 为 true 时客户端已经知道别名，只发送别名
+*/
+func (s *topicAliases) get(topic string) (alias uint16, known bool) {
+	if s.max == 0 {
+		return 0, false
+	}
+
+	if a, ok := s.aliases[topic]; ok {
+		return a, true
+	}
+
+	if len(s.aliases) >= int(s.max) {
+		return 0, false
+	}
+
+	// 第一次发送只记录主题
+	if _, ok := s.seen[topic]; !ok {
+		if len(s.seen) >= topicAliasSeenSize {
+			s.seen = make(map[string]struct{})
+		}
+		s.seen[topic] = struct{}{}
+		return 0, false
+	}
+
+	delete(s.seen, topic)
+
+	alias = uint16(len(s.aliases) + 1)
+	s.aliases[topic] = alias
+
+	return alias, false
+}
+
+/*
+带别名的报文没有发送，删除刚分配的别名，需要持有 lock
+*/
+func (s *topicAliases) release(topic string) {
+	delete(s.aliases, topic)
+}
+
+/*
+客户端 CONNECT 中的主题别名最大值
+*/
+func clientTopicAliasMaximum(p *proto.CONNECTProtocol) uint16 {
+	return p.TopicAliasMaximum
+}
+
+/*
+发送 PUBLISH 给客户端，使用主题别名
+分配别名和发送在同一个锁中，保证客户端先收到带主题的报文
+返回 writePacket 的错误，没有发送时删除刚分配的别名
+*/
+func (s *Conn) sendPublish(msg *message, id uint16, dup bool) error {
+	s.aliasOut.lock.Lock()
+	defer s.aliasOut.lock.Unlock()
+
+	alias, known := s.aliasOut.get(msg.topic)
+
+	by, err := newMqttDataPack().packPUBLISH(msg, id, dup, alias, known)
+	if err == nil {
+		err = s.writePacket(by)
+	}
+
+	if err != nil && alias > 0 && !known {
+		s.aliasOut.release(msg.topic)
+	}
+
+	return err
+}
+
+/*
+收到的 PUBLISH 使用主题别名时，替换成主题
+1，别名为 0 或者超过服务端主题别名最大值，返回 Topic_A_i
+2，有主题时保存别名，没有主题时使用别名对应的主题，别名不存在返回 Topic_A_i
+3，没有别名也没有主题返回 Protocol_Error
+*/
+func (s *Conn) resolveTopicAlias(p proto.ImplMqttProto) uint8 {
+	sp, ok := p.(*proto.PUBLISHProtocol)
+	if !ok {
+		return 0
+	}
+
+	if sp.TopicAlias == 0 {
+		if sp.TopicName == "" {
+			return disconnectProtocolError
+		}
+		return 0
+	}
+
+	if sp.TopicAlias > s.topicAliasMaximum {
+		return disconnectTopicAliasInvalid
+	}
+
+	if sp.TopicName != "" {
+		if s.aliasIn == nil {
+			s.aliasIn = make(map[uint16]string)
+		}
+		s.aliasIn[sp.TopicAlias] = sp.TopicName
+		return 0
+	}
+
+	top, ok := s.aliasIn[sp.TopicAlias]
+	if !ok {
+		return disconnectTopicAliasInvalid
+	}
+
+	sp.TopicName = top
+	sp.TopicNameLength = uint16(len(top))
+
+	return 0
+}
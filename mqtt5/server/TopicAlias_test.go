@@ -0,0 +1,83 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/guihai/ghmqtt/mqtt5/proto"
+)
+
+func TestResolveTopicAlias(t *testing.T) {
+	c := newTestConn(newTestServer(), "c1")
+	c.topicAliasMaximum = 2
+
+	steps := []struct {
+		name  string
+		topic string
+		alias uint16
+		code  uint8
+		want  string
+	}{
+		{"没有别名", "a/b", 0, 0, "a/b"},
+		{"没有别名也没有主题", "", 0, disconnectProtocolError, ""},
+		{"别名 0 以外，没有注册的别名", "", 1, disconnectTopicAliasInvalid, ""},
+		{"注册别名", "a/b", 1, 0, "a/b"},
+		{"使用别名", "", 1, 0, "a/b"},
+		{"修改别名对应的主题", "c/d", 1, 0, "c/d"},
+		{"使用修改后的别名", "", 1, 0, "c/d"},
+		{"注册第二个别名", "e", 2, 0, "e"},
+		{"使用第二个别名", "", 2, 0, "e"},
+		{"超过服务端主题别名最大值", "f", 3, disconnectTopicAliasInvalid, "f"},
+	}
+
+	for _, st := range steps {
+		p := &proto.PUBLISHProtocol{TopicName: st.topic, TopicNameLength: uint16(len(st.topic)), TopicAlias: st.alias}
+		if code := c.resolveTopicAlias(p); code != st.code {
+			t.Fatalf("%s: 想要 0x%02x 收到 0x%02x", st.name, st.code, code)
+		}
+		if st.code == 0 && (p.TopicName != st.want || p.TopicNameLength != uint16(len(st.want))) {
+			t.Fatalf("%s: 想要 %q 收到 %q", st.name, st.want, p.TopicName)
+		}
+	}
+
+	if code := c.resolveTopicAlias(&proto.SUBSCRIBEProtocol{}); code != 0 {
+		t.Fatalf("不是 PUBLISH 0x%02x", code)
+	}
+}
+
+func TestTopicAliasesGet(t *testing.T) {
+	a := newTopicAliases(1)
+
+	steps := []struct {
+		name  string
+		topic string
+		alias uint16
+		known bool
+	}{
+		{"第一次发送不分配", "a", 0, false},
+		{"第二次发送分配别名", "a", 1, false},
+		{"客户端已经知道别名", "a", 1, true},
+		{"别名用完", "b", 0, false},
+		{"别名用完后不再分配", "b", 0, false},
+	}
+
+	for _, st := range steps {
+		alias, known := a.get(st.topic)
+		if alias != st.alias || known != st.known {
+			t.Fatalf("%s: 想要 %d %v 收到 %d %v", st.name, st.alias, st.known, alias, known)
+		}
+	}
+
+	// 没有发送时删除别名，重新按第一次发送处理
+	a.release("a")
+	if alias, _ := a.get("a"); alias != 0 {
+		t.Fatalf("不应该分配 %d", alias)
+	}
+	if alias, known := a.get("a"); alias != 1 || known {
+		t.Fatalf("重新分配 %d %v", alias, known)
+	}
+
+	// 客户端不使用别名
+	if alias, _ := newTopicAliases(0).get("a"); alias != 0 {
+		t.Fatalf("不应该分配 %d", alias)
+	}
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"github.com/guihai/ghmqtt/mqtt5/proto"
+	"github.com/guihai/ghmqtt/utils/ghlog"
 	"sync"
+	"time"
 )
 
 /*
@@ -10,9 +12,9 @@ import (
 在配置中选择使用
 */
 type TopicManager struct {
-	// 订阅map  key 是主题名称  值是 map( client是key  值空结构体不占内存)
+	// 订阅map  key 是主题名称  值是 map( client是key  值是订阅的 QoS)
 	//SubMap map[string]*TSet
-	subMapM map[string]map[string]struct{}
+	subMapM map[string]map[string]uint8
 
 	// 主题信息通道 top 是key  值是通道 ，通道内数据是 byte
 	topicMsg map[string]chan []byte
@@ -22,16 +24,6 @@ type TopicManager struct {
 	// 所属服务
 	ofServer *Server
 
-	// 保留消息map
-	retainMsg map[string][]byte
-	// 保留消息锁
-	retainLock sync.RWMutex
-
-	// Qos2 需要存储消息  key 是 标识符 Identifier 内容空结构体,不占内存，只为了存key
-	qos2ID map[uint16]struct{}
-	// 锁
-	qos2Lock sync.RWMutex
-
 	// topmanger
 	tm *TopicWork
 
@@ -44,16 +36,10 @@ type TopicManager struct {
 func newTopicManager(ser *Server) *TopicManager {
 
 	t := &TopicManager{
-		subMapM:  make(map[string]map[string]struct{}),
+		subMapM:  make(map[string]map[string]uint8),
 		ofServer: ser,
 		topicMsg: make(map[string]chan []byte),
 
-		// 保留消息map
-		retainMsg: make(map[string][]byte),
-
-		// 保留标识符map
-		qos2ID: make(map[uint16]struct{}),
-
 		// 客户端的遗嘱消息
 		clientWill: make(map[string]*proto.Will),
 	}
@@ -72,23 +58,22 @@ func (s *TopicManager) msgInPool(p *proto.PUBLISHProtocol) {
 
 /*
 订阅主题
-1，不校验是否存在，map 保证数据唯一
+1，不校验是否存在，map 保证数据唯一，重复订阅更新 QoS
 2,如果没有用户，client 为空
 */
 
-func (s *TopicManager) subTopic(top, client string) {
+func (s *TopicManager) subTopic(top, client string, qos uint8) {
 
+	s.subLock.Lock()
 	if len(s.subMapM[top]) < 1 {
 		// 这个主题还 没有初始化
-		s.subMapM[top] = make(map[string]struct{})
+		s.subMapM[top] = make(map[string]uint8)
 	}
-
-	s.subLock.Lock()
-	s.subMapM[top][client] = struct{}{}
+	s.subMapM[top][client] = qos
 	s.subLock.Unlock()
 
 	// 用户订阅主题后，可以先发送 保留信息
-	s.sendRetainMsg(top, client)
+	s.sendRetainMsg(top, client, qos)
 
 }
 
@@ -96,10 +81,6 @@ func (s *TopicManager) subTopic(top, client string) {
 取消订阅
 */
 func (s *TopicManager) unSubTopic(top, client string) {
-	if len(s.subMapM[top]) < 1 {
-		// 这个主题不存在
-		return
-	}
 
 	s.subLock.Lock()
 	delete(s.subMapM[top], client)
@@ -111,6 +92,26 @@ func (s *TopicManager) unSubTopic(top, client string) {
 
 }
 
+/*
+多个主题的订阅用户，每个用户只返回一次，QoS 取匹配的订阅中最大的
+*/
+func (s *TopicManager) getSubClients(tops []string) map[string]uint8 {
+
+	clients := make(map[string]uint8)
+
+	s.subLock.RLock()
+	for _, top := range tops {
+		for cli, qos := range s.subMapM[top] {
+			if old, ok := clients[cli]; !ok || qos > old {
+				clients[cli] = qos
+			}
+		}
+	}
+	s.subLock.RUnlock()
+
+	return clients
+}
+
 /*
 查询主题的订阅用户 返回用户 列表
 */
@@ -162,112 +163,101 @@ func (s *TopicManager) getTopList() []string {
 /*
 设置保留消息
 每个主题只保存一条保留消息，所以可以直接赋值，如果byte 为空，就是删除
+expiry 是消息过期间隔，秒，0 不过期，存储没有实现 RetainMsgStore 时只保存消息内容
 */
-func (s *TopicManager) setRetainMsg(top string, by []byte) {
+func (s *TopicManager) setRetainMsg(top string, by []byte, qos uint8, expiry uint32) {
 
-	if len(by) < 1 {
-		s.retainLock.Lock()
-		delete(s.retainMsg, top)
-		s.retainLock.Unlock()
+	var err error
+	if ms, ok := s.ofServer.store.(RetainMsgStore); ok {
+		err = ms.SetRetainMsg(top, &RetainMsg{Payload: by, Qos: qos, ExpireAt: expireTime(expiry)})
+	} else {
+		err = s.ofServer.store.SetRetain(top, by)
 	}
-	// 直接赋值
-	s.retainLock.Lock()
-	s.retainMsg[top] = by
-	s.retainLock.Unlock()
 
-	//fmt.Println("存储保留消息成功，", top)
-	//fmt.Println("保留消息个数", len(s.RetainMsg))
+	if err != nil {
+		s.ofServer.logger.Warn("【保留消息】保存失败", ghlog.Topic(top), ghlog.Err(err))
+	}
 
 }
 
 /*
 获取保留信息
+过期的保留消息删除
 */
-func (s *TopicManager) getRetainMsg(top string) ([]byte, bool) {
+func (s *TopicManager) getRetainMsg(top string) (*message, bool) {
 	// todo 通配符保留信息
 
-	s.retainLock.RLock()
-	by, ok := s.retainMsg[top]
-	s.retainLock.RUnlock()
+	rm := &RetainMsg{}
+	var ok bool
+	var err error
+	if ms, isMs := s.ofServer.store.(RetainMsgStore); isMs {
+		var m *RetainMsg
+		if m, ok, err = ms.GetRetainMsg(top); ok {
+			rm = m
+		}
+	} else {
+		rm.Payload, ok, err = s.ofServer.store.GetRetain(top)
+	}
 
-	if !ok {
-		return []byte{}, ok
+	if err != nil {
+		s.ofServer.logger.Warn("【保留消息】读取失败", ghlog.Topic(top), ghlog.Err(err))
 	}
 
-	return by, ok
+	if !ok || len(rm.Payload) < 1 {
+		return nil, false
+	}
+
+	if _, ok := expiryLeft(rm.ExpireAt, time.Now()); !ok {
+		s.ofServer.logger.Debug("【保留消息】过期", ghlog.Topic(top))
+		s.setRetainMsg(top, nil, 0, 0)
+		return nil, false
+	}
+
+	msg := newMessage(top, rm.Payload, rm.Qos, 0)
+	msg.expireAt = rm.ExpireAt
+	msg.retain = true
+
+	return msg, true
 }
 
 /*
-发送保留消息
+删除过期的保留消息，存储没有实现 RetainMsgStore 时保留消息不过期
 */
-func (s *TopicManager) sendRetainMsg(top string, client string) {
+func (s *TopicManager) removeExpiredRetain(now time.Time) {
 
-	bys, ok := s.getRetainMsg(top)
-
-	if !ok || len(bys) < 1 {
+	ms, ok := s.ofServer.store.(RetainMsgStore)
+	if !ok {
 		return
 	}
 
-	// todo 创建发布协议   按照 48 创建
-	p := &proto.PUBLISHProtocol{
-		Fixed: &proto.Fixed{
-			HeaderFlag: proto.PUBLISH,
-			MsgLen:     0,
-			Data:       nil,
-		},
-		TopicNameLength:  uint16(len(top)),
-		TopicName:        top,
-		PacketIdentifier: [2]byte{},
-		PropertiesLength: 0, // 默认0
-		Payload:          bys,
-	}
-
-	// 长度标识2 个 + 属性1个
-	p.MsgLen = 2 + 1 + uint32(p.TopicNameLength) + uint32(len(bys))
-
-	by2, err := p.Pack()
-
-	// 发送数据
-	con, err := s.ofServer.connMer.getConn(client)
-
+	n, err := ms.RemoveExpired(now)
 	if err != nil {
+		s.ofServer.logger.Warn("【保留消息】删除过期消息失败", ghlog.Err(err))
 		return
 	}
 
-	con.sendByte(by2)
-
+	if n > 0 {
+		s.ofServer.logger.Debug("【保留消息】删除过期消息", ghlog.Int("count", n))
+	}
 }
 
 /*
-保存 Qos2 标识符
-不需要校验是否已存在，直接添加
+订阅后发送保留消息，通过会话发送，使用会话的报文标识符和主题别名
+QoS 取保留消息和订阅中较小的，设置 RETAIN 标志
 */
-func (s *TopicManager) setQos2ID(id uint16) {
-	// 直接赋值
-	s.qos2Lock.Lock()
-	s.qos2ID[id] = struct{}{}
-	s.qos2Lock.Unlock()
-}
+func (s *TopicManager) sendRetainMsg(top string, client string, qos uint8) {
 
-/*
-查找 Qos2ID 对应的key 存在就返回true
-*/
-func (s *TopicManager) getQos2ID(id uint16) bool {
-	s.qos2Lock.RLock()
-	_, ok := s.qos2ID[id]
-	s.qos2Lock.RUnlock()
+	msg, ok := s.getRetainMsg(top)
+	if !ok {
+		return
+	}
 
-	return ok
-}
+	sess, ok := s.ofServer.sessMer.getSession(client)
+	if !ok {
+		return
+	}
 
-/*
-完成Qos2 全部流程 移出key
-*/
-func (s *TopicManager) removeQos2ID(id uint16) {
-	// 直接删除
-	s.qos2Lock.Lock()
-	delete(s.qos2ID, id)
-	s.qos2Lock.Unlock()
+	sess.deliver(msg.withQos(qos), s.ofServer.getConfig().SessionQueueSize)
 
 }
 
@@ -311,51 +301,11 @@ func (s *TopicManager) removeClientWill(client string) {
 }
 
 /*
-建立遗嘱的客户端，关闭
-发送其遗嘱 信息给订阅者客户端
+发送遗嘱 信息给订阅者客户端
 */
-func (s *TopicManager) sendClientWill(client string) {
-
-	will, ok := s.getClientWill(client)
-
-	if !ok {
-		return
-	}
-
-	// todo 创建发布协议   按照 48 创建
-	p := &proto.PUBLISHProtocol{
-		Fixed: &proto.Fixed{
-			HeaderFlag: proto.PUBLISH,
-			MsgLen:     0,
-			Data:       nil,
-		},
-		TopicNameLength:  uint16(len(will.WillTopic)),
-		TopicName:        will.WillTopic,
-		PacketIdentifier: [2]byte{},
-		PropertiesLength: 0,
-		Payload:          []byte(will.WillMessage),
-	}
-
-	// 长度标识2 个 属性1个
-	p.MsgLen = 2 + 1 + uint32(p.TopicNameLength) + uint32(len(p.Payload))
-
-	by2, err := p.Pack()
-
-	if err != nil {
-		return
-	}
-
-	// 发送数据
-	for scli, _ := range s.subMapM[will.WillTopic] {
-
-		con, err := s.ofServer.connMer.getConn(scli)
-
-		if err != nil {
-			continue
-		}
-		con.sendByte(by2)
-	}
+func (s *TopicManager) sendWill(will *proto.Will) {
 
+	s.tm.matchSend(newWillMessage(will))
 }
 
 /*
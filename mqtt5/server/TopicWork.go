@@ -1,13 +1,11 @@
 package server
 
 import (
-	"fmt"
 	"github.com/guihai/ghmqtt/mqtt5/proto"
-	"github.com/guihai/ghmqtt/utils"
-	"github.com/guihai/ghmqtt/utils/zaplog"
-	"go.uber.org/zap"
+	"github.com/guihai/ghmqtt/utils/ghlog"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,6 +20,12 @@ type TopicWork struct {
 	// WorkPoolIsOn
 	poolOn bool
 
+	// 关闭协程池信号
+	quitChan chan struct{}
+	quitOnce sync.Once
+	// 等待工作单位全部退出
+	wg sync.WaitGroup
+
 	// 打包工具
 	dp *MqttDataPack
 
@@ -33,11 +37,12 @@ func newTopicWork(top *TopicManager) *TopicWork {
 
 	r := &TopicWork{
 		// 创建协程池
-		workPoolSize: utils.GO.WorkPoolSize,
+		workPoolSize: top.ofServer.getConfig().WorkPoolSize,
 		//一个worker对应一个queue
-		taskQueue: make([]chan *proto.PUBLISHProtocol, utils.GO.WorkPoolSize),
+		taskQueue: make([]chan *proto.PUBLISHProtocol, top.ofServer.getConfig().WorkPoolSize),
 
-		poolOn: false, // 协程池未启动
+		poolOn:   false, // 协程池未启动
+		quitChan: make(chan struct{}),
 
 		// 打包工具
 		dp: newMqttDataPack(),
@@ -69,9 +74,10 @@ func (s *TopicWork) startWorkerPool() {
 	for i := 0; i < int(s.workPoolSize); i++ {
 
 		// 初始化任务队列的管道
-		s.taskQueue[i] = make(chan *proto.PUBLISHProtocol, utils.GO.TaskQueueMaxSize)
+		s.taskQueue[i] = make(chan *proto.PUBLISHProtocol, s.ofTopic.ofServer.getConfig().TaskQueueMaxSize)
 
 		// 启动一个工作单位
+		s.wg.Add(1)
 		go s.startWorker(i, s.taskQueue[i])
 	}
 
@@ -82,17 +88,39 @@ func (s *TopicWork) startWorkerPool() {
 开启工作单位
 */
 func (s *TopicWork) startWorker(i int, pubs chan *proto.PUBLISHProtocol) {
-	zaplog.ZapLogger.Info("【PUBLISH协程池】 创建工作者", zap.Int("编号", i))
+	s.ofTopic.ofServer.logger.Info("【PUBLISH协程池】 创建工作者", ghlog.Int("编号", i))
+	defer s.wg.Done()
+
 	//不断的等待队列中的消息,然后进行路由处理
 	for {
 		select {
 		case re := <-pubs:
 			s.sendPub(re)
+		case <-s.quitChan:
+			// 处理完队列中剩余的任务再退出
+			for {
+				select {
+				case re := <-pubs:
+					s.sendPub(re)
+				default:
+					return
+				}
+			}
 		}
 	}
 
 }
 
+/*
+关闭协程池，等待队列中的任务处理完成
+*/
+func (s *TopicWork) stopWorkerPool() {
+	s.quitOnce.Do(func() {
+		close(s.quitChan)
+	})
+	s.wg.Wait()
+}
+
 //将消息交给TaskQueue,由worker进行处理
 func (s *TopicWork) sendReqToTaskQueue(pub *proto.PUBLISHProtocol) {
 	// 采用 链接id 取余数 放入对应的消息队列
@@ -102,7 +130,7 @@ func (s *TopicWork) sendReqToTaskQueue(pub *proto.PUBLISHProtocol) {
 	rand.Seed(time.Now().Unix())
 	wid := (dHash(cid) + rand.Uint32()) % s.workPoolSize
 
-	fmt.Println("添加 pub topname =", cid, "到 workerID=", wid)
+	s.ofTopic.ofServer.logger.Debug("【PUBLISH协程池】添加", ghlog.Topic(cid), ghlog.Uint32("worker", wid))
 
 	s.taskQueue[wid] <- pub
 }
@@ -112,65 +140,32 @@ func (s *TopicWork) sendReqToTaskQueue(pub *proto.PUBLISHProtocol) {
 */
 func (s *TopicWork) sendPub(re *proto.PUBLISHProtocol) {
 
-	// 根据获取的消息协议，创造新的消息协议
-	// 按照48 创建 不需要标识符 消息长度
-	p := &proto.PUBLISHProtocol{
-		Fixed: &proto.Fixed{
-			HeaderFlag: proto.PUBLISH,
-			MsgLen:     0,
-			Data:       nil,
-		},
-		TopicNameLength:  re.TopicNameLength,
-		TopicName:        re.TopicName,
-		PacketIdentifier: [2]byte{},
-		PropertiesLength: 0, // 属性0
-		Payload:          re.Payload,
-	}
-
-	// 长度标识2 个 属性 1个
-	p.MsgLen = 2 + 1 + uint32(p.TopicNameLength) + uint32(len(p.Payload))
-
-	by2, err := p.Pack()
-
-	if err != nil {
-		return
-	}
-
-	// 通配符匹配 获取最终要发布的 client
-	s.matchSend(re.TopicName, by2)
+	// 每个订阅者按自己的 QoS 和报文标识符打包
+	s.matchSend(newPublishMessage(re))
 }
 
-func (s *TopicWork) matchSend(topic string, data []byte) {
-	tol := MatchTopic(topic)
+/*
+通配符匹配 获取最终要发布的 client
+每个订阅者只发送一次，QoS 取发布和订阅中较小的
+*/
+func (s *TopicWork) matchSend(msg *message) {
+	tol := MatchTopic(msg.topic)
 	if len(tol) < 1 {
 		return // 不发布
 	}
 
-	// 链接map
-	var conMap = make(map[*Conn]struct{})
+	max := s.ofTopic.ofServer.getConfig().SessionQueueSize
 
-	for _, stop := range tol {
+	for client, qos := range s.ofTopic.getSubClients(tol) {
 
-		for client, _ := range s.ofTopic.subMapM[stop] {
+		sess, ok := s.ofTopic.ofServer.sessMer.getSession(client)
 
-			con, err := s.ofTopic.ofServer.connMer.getConn(client)
-
-			if err != nil {
-				continue
-			}
-
-			// 检查是否出现过
-			_, ok := conMap[con]
-			if ok {
-				// 有值，已经存入了
-				continue
-			}
-			// 不存在值
-			// 发布
-			con.sendByte(data)
-			// 添加到map
-			conMap[con] = struct{}{}
+		if !ok {
+			continue
 		}
+
+		// 发布，离线的会话保存到队列
+		sess.deliver(msg.withQos(qos), max)
 	}
 
 }
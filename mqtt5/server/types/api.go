@@ -11,4 +11,27 @@ type PublishMsg struct {
 	Retain bool
 	// Qos级别
 	Qos uint8
+	// 消息过期间隔，秒，0 不过期
+	MessageExpiry uint32
+}
+
+// 在线链接信息
+type ConnItem struct {
+	ClientID string `json:"ClientID"`
+	// 客户端地址，开启 PROXY 协议时是真实地址
+	RemoteAddr string `json:"RemoteAddr"`
+	// 所属监听
+	Listener string `json:"Listener"`
+	// 协议级别 4 是 3.1.1，5 是 5.0
+	Version uint8 `json:"Version"`
+	// 发送队列中的报文数
+	QueueLen int `json:"QueueLen"`
+}
+
+// 重新加载配置结果
+type ReloadResult struct {
+	// 已经生效的配置
+	Applied []string `json:"Applied"`
+	// 需要重启服务才能生效的配置，保持原值
+	Restart []string `json:"Restart"`
 }
@@ -0,0 +1,431 @@
+package utils
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/*
+配置文件加载
+1，从默认配置开始，文件中没有设置的字段使用默认值
+2，根据扩展名解析 json, toml, yaml 文件
+3，环境变量覆盖，变量名 GHMQTT_ 加字段路径，例如 GHMQTT_PORT，GHMQTT_LOGCFG_LEVEL，GHMQTT_MQTTCLIENT_ADMINPSD
+4，校验配置
+*/
+
+const (
+	// 配置文件路径环境变量
+	ConfigEnv = "GHMQTT_CONFIG"
+	// 配置字段环境变量前缀
+	EnvPrefix = "GHMQTT_"
+)
+
+/*
+配置文件路径，命令行参数优先，没有时使用环境变量 GHMQTT_CONFIG
+*/
+func ConfigPath(flagPath string) string {
+	if flagPath != "" {
+		return flagPath
+	}
+
+	return os.Getenv(ConfigEnv)
+}
+
+/*
+加载配置，path 为空时只使用默认值和环境变量
+*/
+func LoadConfig(path string) (*GlobalObj, error) {
+
+	cfg := DefaultConfig()
+
+	if path != "" {
+		if err := decodeFile(path, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(EnvPrefix, os.Environ(), cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+/*
+根据扩展名解析配置文件，解析到已有默认值的配置上
+*/
+func decodeFile(path string, cfg *GlobalObj) error {
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.New("读取配置文件失败 " + err.Error())
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(buf, cfg)
+	case ".toml":
+		_, err = toml.Decode(string(buf), cfg)
+	case ".yaml", ".yml":
+		err = decodeYAML(buf, cfg)
+	default:
+		return errors.New("不支持的配置文件类型 " + path + "，支持 json, toml, yaml")
+	}
+
+	if err != nil {
+		return errors.New("解析配置文件失败 " + path + " " + err.Error())
+	}
+
+	return nil
+}
+
+/*
+yaml 先解析成通用结构再转成 json 解析
+字段名和 json 一样不区分大小写，不需要给每个字段加 yaml 标签
+*/
+func decodeYAML(buf []byte, cfg *GlobalObj) error {
+
+	var raw interface{}
+	if err := yaml.Unmarshal(buf, &raw); err != nil {
+		return err
+	}
+
+	js, err := json.Marshal(yamlToJSON(raw))
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(js, cfg)
+}
+
+/*
+yaml 的 map[interface{}]interface{} 转成 json 可以编码的 map[string]interface{}
+*/
+func yamlToJSON(v interface{}) interface{} {
+
+	switch vv := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, val := range vv {
+			m[fmt.Sprint(k)] = yamlToJSON(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range vv {
+			vv[i] = yamlToJSON(val)
+		}
+		return vv
+	}
+
+	return v
+}
+
+/*
+环境变量覆盖配置
+变量名是前缀加大写的字段路径，嵌套结构使用 _ 连接
+切片使用逗号分隔，结构体切片(Listeners) 使用 json
+*/
+func applyEnv(prefix string, environ []string, cfg *GlobalObj) error {
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv[:i], prefix) || kv[:i] == ConfigEnv {
+			continue
+		}
+		env[kv[:i]] = kv[i+1:]
+	}
+
+	if len(env) == 0 {
+		return nil
+	}
+
+	return applyEnvStruct(strings.TrimSuffix(prefix, "_"), env, reflect.ValueOf(cfg).Elem())
+}
+
+func applyEnvStruct(prefix string, env map[string]string, v reflect.Value) error {
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// 未导出字段
+			continue
+		}
+
+		name := prefix + "_" + strings.ToUpper(f.Name)
+		fv := v.Field(i)
+
+		// 嵌套配置结构体
+		if fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct && !hasEnv(env, name) {
+			if !hasEnvPrefix(env, name+"_") {
+				continue
+			}
+			if fv.IsNil() {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+			if err := applyEnvStruct(name, env, fv.Elem()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		val, ok := env[name]
+		if !ok {
+			continue
+		}
+
+		if err := setEnvValue(fv, val); err != nil {
+			return fmt.Errorf("环境变量 %s=%q 错误 %v", name, val, err)
+		}
+	}
+
+	return nil
+}
+
+func hasEnv(env map[string]string, name string) bool {
+	_, ok := env[name]
+	return ok
+}
+
+func hasEnvPrefix(env map[string]string, prefix string) bool {
+	for k := range env {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+环境变量的字符串值写入字段
+*/
+func setEnvValue(v reflect.Value, s string) error {
+
+	// 日志级别等实现了 TextUnmarshaler 的类型，例如 GHMQTT_LOGCFG_LEVEL=debug
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			if err := u.UnmarshalText([]byte(s)); err == nil {
+				return nil
+			}
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			list := make([]string, 0)
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			v.Set(reflect.ValueOf(list))
+			return nil
+		}
+		return json.Unmarshal([]byte(s), v.Addr().Interface())
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setEnvValue(v.Elem(), s)
+	default:
+		return json.Unmarshal([]byte(s), v.Addr().Interface())
+	}
+
+	return nil
+}
+
+/*
+校验配置，返回所有错误
+*/
+func (s *GlobalObj) Validate() error {
+
+	var errs []string
+	add := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, a...))
+	}
+
+	if s.Name == "" {
+		add("Name 不能为空")
+	}
+	if s.Port == 0 && len(s.Listeners) == 0 {
+		// 需要随机端口时使用 Listeners，地址 ip:0
+		add("Port 必须在 1-65535 之间")
+	}
+	if s.IP != "" && net.ParseIP(s.IP) == nil {
+		add("IP %q 不是有效的地址", s.IP)
+	}
+	switch s.Tcp {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		add("Tcp 必须是 tcp, tcp4, tcp6 之一，当前 %q", s.Tcp)
+	}
+	if s.ConnLiveTime == 0 {
+		add("ConnLiveTime 必须大于 0")
+	}
+	if s.WorkPoolSize == 0 {
+		add("WorkPoolSize 必须大于 0")
+	}
+	if s.TaskQueueMaxSize == 0 {
+		add("TaskQueueMaxSize 必须大于 0")
+	}
+	if s.WriteQueueSize == 0 {
+		add("WriteQueueSize 必须大于 0")
+	}
+	switch s.WriteQueuePolicy {
+	case QueueDrop, QueueDisconnect, QueueSpill:
+	default:
+		add("WriteQueuePolicy 必须是 drop, disconnect, spill 之一，当前 %q", s.WriteQueuePolicy)
+	}
+	if s.WriteQueuePolicy == QueueSpill && s.WriteSpillSize == 0 {
+		add("WriteQueuePolicy 为 spill 时 WriteSpillSize 必须大于 0")
+	}
+	if s.WriteTimeout == 0 {
+		add("WriteTimeout 必须大于 0")
+	}
+	switch s.ShutdownWill {
+	case WillSend, WillPersist, WillDrop:
+	default:
+		add("ShutdownWill 必须是 send, persist, drop 之一，当前 %q", s.ShutdownWill)
+	}
+	switch s.DuplicateClient {
+	case DuplicateTakeover, DuplicateReject:
+	default:
+		add("DuplicateClient 必须是 takeover, reject 之一，当前 %q", s.DuplicateClient)
+	}
+
+	if s.TLS != nil && s.TLS.Enable {
+		if s.TLS.Port == 0 {
+			add("TLS.Port 必须在 1-65535 之间")
+		}
+		validateTLS("TLS", s.TLS, add)
+	}
+
+	if s.WebSocket != nil && s.WebSocket.Enable {
+		if s.WebSocket.Port == 0 {
+			add("WebSocket.Port 必须在 1-65535 之间")
+		}
+		if !strings.HasPrefix(s.WebSocket.Path, "/") {
+			add("WebSocket.Path 必须以 / 开头，当前 %q", s.WebSocket.Path)
+		}
+	}
+
+	if s.ConnRate != nil {
+		if s.ConnRate.PerIPRate < 0 || s.ConnRate.GlobalRate < 0 {
+			add("ConnRate 速率不能小于 0")
+		}
+		for _, a := range s.ConnRate.Allowlist {
+			if _, _, err := net.ParseCIDR(a); err != nil && net.ParseIP(a) == nil {
+				add("ConnRate.Allowlist %q 不是有效的 CIDR 或者 IP", a)
+			}
+		}
+	}
+
+	names := make(map[string]struct{})
+	for i, lc := range s.Listeners {
+		prefix := fmt.Sprintf("Listeners[%d]", i)
+		if lc == nil {
+			add("%s 不能为空", prefix)
+			continue
+		}
+		if lc.Name == "" {
+			add("%s.Name 不能为空", prefix)
+		} else if _, ok := names[lc.Name]; ok {
+			add("%s.Name %q 重复", prefix, lc.Name)
+		}
+		names[lc.Name] = struct{}{}
+
+		switch lc.Type {
+		case ListenerTCP, ListenerTLS, ListenerWS, ListenerWSS, ListenerUnix:
+		default:
+			add("%s.Type 必须是 tcp, tls, ws, wss, unix 之一，当前 %q", prefix, lc.Type)
+		}
+		if lc.Address == "" {
+			add("%s.Address 不能为空", prefix)
+		} else if lc.Type != ListenerUnix {
+			if _, port, err := net.SplitHostPort(lc.Address); err != nil {
+				add("%s.Address %q 必须是 ip:port", prefix, lc.Address)
+			} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+				add("%s.Address %q 端口必须在 0-65535 之间", prefix, lc.Address)
+			}
+		}
+		if lc.IsTLS() {
+			if lc.TLS == nil {
+				add("%s.TLS 不能为空", prefix)
+			} else {
+				validateTLS(prefix+".TLS", lc.TLS, add)
+			}
+		}
+	}
+
+	if s.LogCfg == nil {
+		add("LogCfg 不能为空")
+	} else if s.LogCfg.Level < -1 || s.LogCfg.Level > 5 {
+		add("LogCfg.Level 必须在 -1 到 5 之间")
+	}
+
+	if s.MQTTClient == nil {
+		add("MQTTClient 不能为空")
+	} else if s.MQTTClient.ClientIDLen == 0 {
+		add("MQTTClient.ClientIDLen 必须大于 0")
+	}
+
+	if len(errs) > 0 {
+		return errors.New("配置错误: " + strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func validateTLS(prefix string, cfg *TLSConfig, add func(string, ...interface{})) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		add("%s 需要设置 CertFile 和 KeyFile", prefix)
+	}
+	switch cfg.IdentityFrom {
+	case IdentityFromNone, IdentityFromCN, IdentityFromSAN:
+	default:
+		add("%s.IdentityFrom 必须是 \"\", cn, san 之一，当前 %q", prefix, cfg.IdentityFrom)
+	}
+}
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+
+	cases := []struct {
+		name  string
+		env   []string
+		get   func(cfg *GlobalObj) interface{}
+		want  interface{}
+		noTLS bool
+	}{
+		{"字符串", []string{"GHMQTT_NAME=broker"}, func(c *GlobalObj) interface{} { return c.Name }, "broker", false},
+		{"uint16", []string{"GHMQTT_PORT=1884"}, func(c *GlobalObj) interface{} { return c.Port }, uint16(1884), false},
+		{"uint32", []string{"GHMQTT_MAXCONNPERUSER=3"}, func(c *GlobalObj) interface{} { return c.MaxConnPerUser }, uint32(3), false},
+		{"嵌套 bool", []string{"GHMQTT_TLS_ENABLE=true"}, func(c *GlobalObj) interface{} { return c.TLS.Enable }, true, false},
+		{"嵌套结构体为空时创建", []string{"GHMQTT_TLS_PORT=9883"}, func(c *GlobalObj) interface{} { return c.TLS.Port }, uint16(9883), true},
+		{"float", []string{"GHMQTT_CONNRATE_PERIPRATE=2.5"}, func(c *GlobalObj) interface{} { return c.ConnRate.PerIPRate }, 2.5, false},
+		{"字符串切片", []string{"GHMQTT_CONNRATE_ALLOWLIST=10.0.0.0/8, 127.0.0.1,"}, func(c *GlobalObj) interface{} { return c.ConnRate.Allowlist }, []string{"10.0.0.0/8", "127.0.0.1"}, false},
+		{"日志级别名称", []string{"GHMQTT_LOGCFG_LEVEL=warn"}, func(c *GlobalObj) interface{} { return int(c.LogCfg.Level) }, 1, false},
+		{"日志级别数字", []string{"GHMQTT_LOGCFG_LEVEL=-1"}, func(c *GlobalObj) interface{} { return int(c.LogCfg.Level) }, -1, false},
+		{"结构体切片使用 json", []string{`GHMQTT_LISTENERS=[{"Name":"a","Type":"tcp","Address":":1883"}]`}, func(c *GlobalObj) interface{} { return c.Listeners[0].Address }, ":1883", false},
+		{"忽略配置文件路径和其他前缀", []string{"GHMQTT_CONFIG=x.json", "OTHER_NAME=x"}, func(c *GlobalObj) interface{} { return c.Name }, "GHMQTT", false},
+	}
+
+	for _, c := range cases {
+		cfg := DefaultConfig()
+		if c.noTLS {
+			cfg.TLS = nil
+		}
+		if err := applyEnv(EnvPrefix, c.env, cfg); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := c.get(cfg); !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("%s: 想要 %v 收到 %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestApplyEnvBadValue(t *testing.T) {
+
+	cases := []string{
+		"GHMQTT_PORT=abc",
+		"GHMQTT_PORT=70000",
+		"GHMQTT_MAXCONN=-1",
+		"GHMQTT_TLS_ENABLE=yes",
+		"GHMQTT_CONNRATE_PERIPRATE=fast",
+		"GHMQTT_LOGCFG_LEVEL=loud",
+		"GHMQTT_LISTENERS=[{",
+	}
+
+	for _, kv := range cases {
+		err := applyEnv(EnvPrefix, []string{kv}, DefaultConfig())
+		if err == nil {
+			t.Fatalf("%s 应该返回错误", kv)
+		}
+		if name := kv[:strings.Index(kv, "=")]; !strings.Contains(err.Error(), name) {
+			t.Fatalf("%s 错误中没有变量名 %v", kv, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("默认配置 %v", err)
+	}
+
+	cases := []struct {
+		set  func(c *GlobalObj)
+		want string
+	}{
+		{func(c *GlobalObj) { c.Name = "" }, "Name 不能为空"},
+		{func(c *GlobalObj) { c.Port = 0 }, "Port 必须在 1-65535 之间"},
+		{func(c *GlobalObj) { c.IP = "1.2.3" }, "IP \"1.2.3\" 不是有效的地址"},
+		{func(c *GlobalObj) { c.Tcp = "udp" }, "Tcp 必须是"},
+		{func(c *GlobalObj) { c.ConnLiveTime = 0 }, "ConnLiveTime 必须大于 0"},
+		{func(c *GlobalObj) { c.WorkPoolSize = 0 }, "WorkPoolSize 必须大于 0"},
+		{func(c *GlobalObj) { c.TaskQueueMaxSize = 0 }, "TaskQueueMaxSize 必须大于 0"},
+		{func(c *GlobalObj) { c.WriteQueueSize = 0 }, "WriteQueueSize 必须大于 0"},
+		{func(c *GlobalObj) { c.WriteQueuePolicy = "block" }, "WriteQueuePolicy 必须是"},
+		{func(c *GlobalObj) { c.WriteQueuePolicy = QueueSpill; c.WriteSpillSize = 0 }, "WriteSpillSize 必须大于 0"},
+		{func(c *GlobalObj) { c.WriteTimeout = 0 }, "WriteTimeout 必须大于 0"},
+		{func(c *GlobalObj) { c.ShutdownWill = "keep" }, "ShutdownWill 必须是"},
+		{func(c *GlobalObj) { c.DuplicateClient = "both" }, "DuplicateClient 必须是"},
+		{func(c *GlobalObj) { c.TLS = &TLSConfig{Enable: true, CertFile: "a", KeyFile: "b"} }, "TLS.Port 必须在 1-65535 之间"},
+		{func(c *GlobalObj) { c.TLS = &TLSConfig{Enable: true, Port: 8883} }, "TLS 需要设置 CertFile 和 KeyFile"},
+		{func(c *GlobalObj) {
+			c.TLS = &TLSConfig{Enable: true, Port: 8883, CertFile: "a", KeyFile: "b", IdentityFrom: "dn"}
+		}, "TLS.IdentityFrom 必须是"},
+		{func(c *GlobalObj) { c.WebSocket.Enable = true; c.WebSocket.Port = 0 }, "WebSocket.Port 必须在 1-65535 之间"},
+		{func(c *GlobalObj) { c.WebSocket.Enable = true; c.WebSocket.Path = "mqtt" }, "WebSocket.Path 必须以 / 开头"},
+		{func(c *GlobalObj) { c.ConnRate.GlobalRate = -1 }, "ConnRate 速率不能小于 0"},
+		{func(c *GlobalObj) { c.ConnRate.Allowlist = []string{"10.0.0.0/33"} }, "ConnRate.Allowlist \"10.0.0.0/33\""},
+		{func(c *GlobalObj) { c.Listeners = []*ListenerConfig{nil} }, "Listeners[0] 不能为空"},
+		{func(c *GlobalObj) { c.Listeners = []*ListenerConfig{{Type: ListenerTCP, Address: ":1"}} }, "Listeners[0].Name 不能为空"},
+		{func(c *GlobalObj) {
+			c.Listeners = []*ListenerConfig{{Name: "a", Type: ListenerTCP, Address: ":1"}, {Name: "a", Type: ListenerTCP, Address: ":2"}}
+		}, "Listeners[1].Name \"a\" 重复"},
+		{func(c *GlobalObj) { c.Listeners = []*ListenerConfig{{Name: "a", Type: "quic", Address: ":1"}} }, "Listeners[0].Type 必须是"},
+		{func(c *GlobalObj) { c.Listeners = []*ListenerConfig{{Name: "a", Type: ListenerTCP}} }, "Listeners[0].Address 不能为空"},
+		{func(c *GlobalObj) { c.Listeners = []*ListenerConfig{{Name: "a", Type: ListenerTCP, Address: "1883"}} }, "必须是 ip:port"},
+		{func(c *GlobalObj) { c.Listeners = []*ListenerConfig{{Name: "a", Type: ListenerTCP, Address: ":70000"}} }, "端口必须在 0-65535 之间"},
+		{func(c *GlobalObj) { c.Listeners = []*ListenerConfig{{Name: "a", Type: ListenerTLS, Address: ":1"}} }, "Listeners[0].TLS 不能为空"},
+		{func(c *GlobalObj) {
+			c.Listeners = []*ListenerConfig{{Name: "a", Type: ListenerWSS, Address: ":1", TLS: &TLSConfig{}}}
+		}, "Listeners[0].TLS 需要设置 CertFile 和 KeyFile"},
+		{func(c *GlobalObj) { c.LogCfg = nil }, "LogCfg 不能为空"},
+		{func(c *GlobalObj) { c.LogCfg.Level = 6 }, "LogCfg.Level 必须在 -1 到 5 之间"},
+		{func(c *GlobalObj) { c.MQTTClient = nil }, "MQTTClient 不能为空"},
+		{func(c *GlobalObj) { c.MQTTClient.ClientIDLen = 0 }, "MQTTClient.ClientIDLen 必须大于 0"},
+	}
+
+	for _, c := range cases {
+		cfg := DefaultConfig()
+		c.set(cfg)
+		err := cfg.Validate()
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("想要 %q 收到 %v", c.want, err)
+		}
+	}
+
+	// 有 Listeners 时可以不设置 Port，返回所有错误
+	cfg := DefaultConfig()
+	cfg.Port = 0
+	cfg.Listeners = []*ListenerConfig{{Name: "a", Type: ListenerTCP, Address: "127.0.0.1:0"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("%v", err)
+	}
+	cfg.Name, cfg.WorkPoolSize = "", 0
+	if err := cfg.Validate(); err == nil || strings.Count(err.Error(), ";") != 1 {
+		t.Fatalf("应该返回两个错误 %v", err)
+	}
+}
+
+func TestClone(t *testing.T) {
+
+	cfg := DefaultConfig()
+	cfg.ConnRate.Allowlist = []string{"10.0.0.0/8"}
+	cfg.Listeners = []*ListenerConfig{{Name: "a", Type: ListenerTLS, Address: ":8883", TLS: &TLSConfig{CertFile: "a.pem"}}, nil}
+
+	c := cfg.Clone()
+	if !reflect.DeepEqual(c, cfg) {
+		t.Fatal("拷贝和原来的配置不同")
+	}
+
+	c.TLS.Port = 1
+	c.WebSocket.Path = "/ws"
+	c.ConnRate.Allowlist[0] = "0.0.0.0/0"
+	c.Listeners[0].Address = ":1"
+	c.Listeners[0].TLS.CertFile = "b.pem"
+	c.LogCfg.Level = 3
+	c.MQTTClient.AdminPSD = "x"
+
+	d := DefaultConfig()
+	if cfg.TLS.Port != d.TLS.Port || cfg.WebSocket.Path != d.WebSocket.Path || cfg.ConnRate.Allowlist[0] != "10.0.0.0/8" ||
+		cfg.Listeners[0].Address != ":8883" || cfg.Listeners[0].TLS.CertFile != "a.pem" ||
+		cfg.LogCfg.Level != d.LogCfg.Level || cfg.MQTTClient.AdminPSD != d.MQTTClient.AdminPSD {
+		t.Fatal("修改拷贝影响了原来的配置")
+	}
+}
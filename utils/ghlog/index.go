@@ -0,0 +1,115 @@
+package ghlog
+
+import (
+	"time"
+)
+
+/*
+日志接口，服务只通过这个接口写日志
+默认使用 zap (zaplog.NewAdapter)，可以适配 slog，zerolog 等其他日志
+每条日志使用字段记录客户端，地址，报文类型，主题等信息
+*/
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+
+	// 返回带有字段的日志，之后写的每条日志都带上这些字段
+	With(fields ...Field) Logger
+}
+
+/*
+日志字段
+*/
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// 服务使用的字段名
+const (
+	KeyClient   = "client"   // 客户标识符
+	KeyAddr     = "addr"     // 客户端地址
+	KeyListener = "listener" // 监听名称
+	KeyPacket   = "packet"   // 报文类型
+	KeyTopic    = "topic"    // 主题
+	KeyError    = "error"    // 错误
+)
+
+func Any(key string, val interface{}) Field {
+	return Field{Key: key, Value: val}
+}
+
+func String(key, val string) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Strings(key string, val []string) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Int(key string, val int) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Uint8(key string, val uint8) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Uint16(key string, val uint16) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Uint32(key string, val uint32) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Duration(key string, val time.Duration) Field {
+	return Field{Key: key, Value: val}
+}
+
+func Client(clientID string) Field {
+	return String(KeyClient, clientID)
+}
+
+func Addr(addr string) Field {
+	return String(KeyAddr, addr)
+}
+
+func Listener(name string) Field {
+	return String(KeyListener, name)
+}
+
+func Packet(name string) Field {
+	return String(KeyPacket, name)
+}
+
+func Topic(topic string) Field {
+	return String(KeyTopic, topic)
+}
+
+/*
+错误字段，err 为空时值为空
+*/
+func Err(err error) Field {
+	return Field{Key: KeyError, Value: err}
+}
+
+/*
+不写任何日志
+*/
+func Nop() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (s nopLogger) Debug(msg string, fields ...Field) {}
+func (s nopLogger) Info(msg string, fields ...Field)  {}
+func (s nopLogger) Warn(msg string, fields ...Field)  {}
+func (s nopLogger) Error(msg string, fields ...Field) {}
+
+func (s nopLogger) With(fields ...Field) Logger {
+	return s
+}
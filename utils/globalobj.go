@@ -1,9 +1,7 @@
 package utils
 
 import (
-	"encoding/json"
 	"github.com/guihai/ghmqtt/utils/zaplog"
-	"io/ioutil"
 )
 
 /*
@@ -19,11 +17,15 @@ type GlobalObj struct {
 	Port uint16
 	// 传输协议
 	Tcp string
-	// 最大连接数
+	// 最大连接数 0 不限制
 	MaxConn uint32
-	// 链接活跃时长，秒，超过时长不活跃会关闭链接
-	ConnLiveTime uint8
-	// 数据包最大值
+	// 每个用户名最大连接数 0 不限制
+	MaxConnPerUser uint32
+	// 等待 CONNECT 的时长，秒，tls 握手和 PROXY 头部也使用此时长
+	ConnLiveTime uint16
+	// 服务端最大保活时间，秒，v5 客户端 KeepAlive 为 0 或者超过时使用此值并在 CONNACK 中通知，3.1.1 客户端不限制，0 不限制
+	MaxKeepAlive uint16
+	// 报文最大长度，字节，包括固定报头，超过断开链接 v5 原因码 0x95，0 不限制
 	MaxPacketSize uint32
 	// 版本号
 	Version string
@@ -33,6 +35,45 @@ type GlobalObj struct {
 	// 协程池任务队列的最大容量
 	TaskQueueMaxSize uint32
 
+	// 每个链接发送队列长度
+	WriteQueueSize uint32
+	// 发送队列满时的处理方式 drop, disconnect, spill，QoS1，QoS2 的 PUBLISH 都退回会话队列
+	WriteQueuePolicy string
+	// spill 方式每个链接最多暂存的报文数，超过后断开链接
+	WriteSpillSize uint32
+	// 写超时，秒
+	WriteTimeout uint16
+
+	// 会话离线时最多保存的消息数，超过丢弃最早的消息，0 不保存
+	// 在线时超过客户端 Receive Maximum 的 QoS1，QoS2 消息也保存在队列中
+	SessionQueueSize uint32
+	// v5 客户端最多同时发送的 QoS1，QoS2 消息数，在 CONNACK 中返回，超过时断开链接，0 不限制
+	ReceiveMaximum uint16
+	// v5 客户端可以使用的主题别名最大值，在 CONNACK 中返回，0 不可以使用主题别名
+	TopicAliasMaximum uint16
+
+	// 会话过期间隔上限，秒，客户端请求的值超过时使用此值并在 CONNACK 中返回，0 不限制
+	MaxSessionExpiry uint32
+	// 相同 clientID 的链接已经在线时的处理方式 takeover, reject
+	DuplicateClient string
+
+	// 关闭服务超时时间，秒，超时后强制关闭剩余链接
+	ShutdownTimeout uint16
+	// 关闭服务时遗嘱的处理方式 send, persist, drop
+	ShutdownWill string
+
+	// TLS 配置
+	TLS *TLSConfig
+
+	// websocket 配置
+	WebSocket *WebSocketConfig
+
+	// 新链接速率限制
+	ConnRate *ConnRateConfig
+
+	// 监听列表，为空时根据 IP，Port，TLS，WebSocket 生成
+	Listeners []*ListenerConfig
+
 	// 日志配置
 	LogCfg *zaplog.LogConfig
 
@@ -53,19 +94,78 @@ type MQTTClient struct {
 
 }
 
-// 定义全局使用的变量
-var GO *GlobalObj
+// 发送队列满时 QoS1，QoS2 PUBLISH 以外报文的处理方式
+const (
+	QueueDrop       = "drop"       // 丢弃 QoS0 报文，其他报文断开链接
+	QueueDisconnect = "disconnect" // 断开链接 v5 原因码 0x97
+	QueueSpill      = "spill"      // 暂存到链接，超过 WriteSpillSize 断开链接，链接断开时丢弃
+)
+
+// 相同 clientID 的链接已经在线时的处理方式
+const (
+	DuplicateTakeover = "takeover" // 新链接接管会话，原来的链接 v5 收到 DISCONNECT 0x8E 后关闭
+	DuplicateReject   = "reject"   // 拒绝新链接，CONNACK 0x85，3.1.1 返回 0x02
+)
+
+// 关闭服务时遗嘱的处理方式
+const (
+	WillSend    = "send"    // 发送给订阅者
+	WillPersist = "persist" // 交给注册的保存方法，下次启动后处理
+	WillDrop    = "drop"    // 丢弃
+)
+
+/*
+新链接速率限制配置，令牌桶，速率为 0 不限制
+*/
+type ConnRateConfig struct {
+	// 每个 IP 每秒新链接数
+	PerIPRate float64
+	// 每个 IP 突发新链接数
+	PerIPBurst uint32
+	// 全局每秒新链接数
+	GlobalRate float64
+	// 全局突发新链接数
+	GlobalBurst uint32
+	// 不限制的网段 CIDR 或者 IP，例如 10.0.0.0/8
+	Allowlist []string
+}
+
+/*
+深拷贝，包括 Allowlist，nil 返回 nil
+*/
+func (s *ConnRateConfig) Clone() *ConnRateConfig {
+	if s == nil {
+		return nil
+	}
+
+	c := *s
+	c.Allowlist = append([]string(nil), s.Allowlist...)
+	return &c
+}
+
+/*
+websocket 监听配置  mqtt over websocket
+*/
+type WebSocketConfig struct {
+	// 是否开启 websocket 监听
+	Enable bool
+	// 监听端口 默认 8083
+	Port uint16
+	// 监听路径 默认 /mqtt
+	Path string
+}
 
-// 初始化 全局变量，使用 init 方法 ，包被调用的时候会先调用这个方法
-func init() {
-	//初始化GlobalObject变量，设置一些默认值
-	GO = &GlobalObj{
+/*
+默认配置，服务使用 server.WithConfig 设置配置，没有设置时使用默认配置
+*/
+func DefaultConfig() *GlobalObj {
+	return &GlobalObj{
 		Name:          "GHMQTT",
 		IP:            "0.0.0.0",
 		Port:          1883,
 		Tcp:           "tcp4",
 		MaxConn:       100,
-		MaxPacketSize: 2048,
+		MaxPacketSize: 1048576, // 默认1M
 		Version:       "v1.0",
 		ConnLiveTime:  120, // 默认120秒
 
@@ -74,6 +174,33 @@ func init() {
 		// 协程池任务队列的最大容量
 		TaskQueueMaxSize: 1024,
 
+		WriteQueueSize:   1024,
+		WriteQueuePolicy: QueueDrop,
+		WriteSpillSize:   10240,
+		WriteTimeout:     10,
+
+		SessionQueueSize:  1000,
+		ReceiveMaximum:    100,
+		TopicAliasMaximum: 10,
+		MaxSessionExpiry:  86400,
+		DuplicateClient:   DuplicateTakeover,
+
+		ShutdownTimeout: 10,
+		ShutdownWill:    WillSend,
+
+		TLS: &TLSConfig{
+			Enable: false,
+			Port:   8883,
+		},
+
+		WebSocket: &WebSocketConfig{
+			Enable: false,
+			Port:   8083,
+			Path:   "/mqtt",
+		},
+
+		ConnRate: &ConnRateConfig{},
+
 		LogCfg: &zaplog.LogConfig{
 			Filename:   "./log/logs.json",
 			MaxSize:    128,
@@ -92,21 +219,37 @@ func init() {
 			AdminPSD:    "0608",
 		},
 	}
-
-	// 加载配置参数
-	//reloadConfig()
-
-	zaplog.InitLogger(GO.LogCfg)
-
 }
 
-func reloadConfig() {
-	buf, err := ioutil.ReadFile("etc/etc.json")
-	if err != nil {
-		panic(err)
+/*
+深拷贝配置，修改拷贝中的监听，TLS，速率限制，日志等配置不影响原来的配置
+*/
+func (s *GlobalObj) Clone() *GlobalObj {
+	c := *s
+
+	if s.TLS != nil {
+		tls := *s.TLS
+		c.TLS = &tls
+	}
+	if s.WebSocket != nil {
+		ws := *s.WebSocket
+		c.WebSocket = &ws
+	}
+	c.ConnRate = s.ConnRate.Clone()
+	if s.Listeners != nil {
+		c.Listeners = make([]*ListenerConfig, len(s.Listeners))
+		for i, lc := range s.Listeners {
+			c.Listeners[i] = lc.Clone()
+		}
 	}
-	err = json.Unmarshal(buf, GO)
-	if err != nil {
-		panic(err)
+	if s.LogCfg != nil {
+		log := *s.LogCfg
+		c.LogCfg = &log
 	}
+	if s.MQTTClient != nil {
+		mc := *s.MQTTClient
+		c.MQTTClient = &mc
+	}
+
+	return &c
 }
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"net"
+	"strconv"
+)
+
+// 监听类型
+const (
+	ListenerTCP  = "tcp"  // 普通 tcp
+	ListenerTLS  = "tls"  // tcp + tls
+	ListenerWS   = "ws"   // websocket
+	ListenerWSS  = "wss"  // websocket + tls
+	ListenerUnix = "unix" // unix domain socket
+)
+
+/*
+监听配置，一个服务可以同时开启多个监听
+所有监听共享链接管理器和主题管理器
+*/
+type ListenerConfig struct {
+	// 监听名称，唯一
+	Name string
+	// 监听类型 tcp, tls, ws, wss, unix
+	Type string
+	// 网络类型 tcp, tcp4, tcp6  unix 类型不使用
+	Network string
+	// 监听地址 ip:port ，unix 类型是 socket 文件路径
+	Address string
+	// websocket 路径
+	Path string
+
+	// tls, wss 使用的证书配置
+	TLS *TLSConfig
+
+	// 本监听最大连接数 0 不限制
+	MaxConn uint32
+	// 必须提供用户名密码
+	RequireAuth bool
+
+	// 开启 PROXY 协议 v1 v2，在负载均衡后面使用，链接必须先发送 PROXY 头部
+	ProxyProtocol bool
+}
+
+/*
+深拷贝，包括 TLS，nil 返回 nil
+*/
+func (s *ListenerConfig) Clone() *ListenerConfig {
+	if s == nil {
+		return nil
+	}
+
+	c := *s
+	if s.TLS != nil {
+		tls := *s.TLS
+		c.TLS = &tls
+	}
+	return &c
+}
+
+/*
+是否是 tls 监听
+*/
+func (s *ListenerConfig) IsTLS() bool {
+	return s.Type == ListenerTLS || s.Type == ListenerWSS
+}
+
+/*
+是否是 websocket 监听
+*/
+func (s *ListenerConfig) IsWebSocket() bool {
+	return s.Type == ListenerWS || s.Type == ListenerWSS
+}
+
+/*
+获取监听列表
+没有配置 Listeners 时，使用 IP，Port，TLS，WebSocket 生成默认监听
+*/
+func (s *GlobalObj) GetListeners() []*ListenerConfig {
+
+	if len(s.Listeners) > 0 {
+		return s.Listeners
+	}
+
+	list := []*ListenerConfig{
+		{
+			Name:    ListenerTCP,
+			Type:    ListenerTCP,
+			Network: s.Tcp,
+			Address: net.JoinHostPort(s.IP, strconv.Itoa(int(s.Port))),
+		},
+	}
+
+	if s.TLS != nil && s.TLS.Enable {
+		list = append(list, &ListenerConfig{
+			Name:    ListenerTLS,
+			Type:    ListenerTLS,
+			Network: s.Tcp,
+			Address: net.JoinHostPort(s.IP, strconv.Itoa(int(s.TLS.Port))),
+			TLS:     s.TLS,
+		})
+	}
+
+	if s.WebSocket != nil && s.WebSocket.Enable {
+		list = append(list, &ListenerConfig{
+			Name:    ListenerWS,
+			Type:    ListenerWS,
+			Network: s.Tcp,
+			Address: net.JoinHostPort(s.IP, strconv.Itoa(int(s.WebSocket.Port))),
+			Path:    s.WebSocket.Path,
+		})
+	}
+
+	return list
+}
@@ -0,0 +1,281 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+PROXY protocol v1 v2 解析
+负载均衡在 mqtt 数据前写入 PROXY 头部，头部中是客户端的真实地址
+https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+*/
+
+// v2 头部签名 12个字节
+var v2Sig = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// v1 头部前缀
+var v1Prefix = []byte("PROXY ")
+
+// v1 头部最大长度 包含 \r\n
+const v1MaxLen = 107
+
+/*
+监听封装，接收的链接都需要解析 PROXY 头部
+*/
+type Listener struct {
+	net.Listener
+
+	// 读取头部超时时间
+	timeout time.Duration
+}
+
+func NewListener(lis net.Listener, timeout time.Duration) *Listener {
+	return &Listener{
+		Listener: lis,
+		timeout:  timeout,
+	}
+}
+
+/*
+接收链接，不在这里读取头部，防止慢客户端阻塞接收
+头部在链接第一次读取或者获取地址时解析
+*/
+func (s *Listener) Accept() (net.Conn, error) {
+	conn, err := s.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewConn(conn, s.timeout), nil
+}
+
+/*
+链接封装，RemoteAddr 返回头部中的客户端地址
+*/
+type Conn struct {
+	net.Conn
+
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	// 只解析一次
+	once sync.Once
+	// 头部中的客户端地址，LOCAL 命令或者 UNKNOWN 时为空
+	srcAddr net.Addr
+	// 解析错误
+	err error
+}
+
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	return &Conn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}
+}
+
+func (s *Conn) Read(b []byte) (int, error) {
+	s.once.Do(s.readHeader)
+
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	return s.reader.Read(b)
+}
+
+/*
+客户端真实地址，头部没有地址时返回代理地址
+*/
+func (s *Conn) RemoteAddr() net.Addr {
+	s.once.Do(s.readHeader)
+
+	if s.srcAddr != nil {
+		return s.srcAddr
+	}
+
+	return s.Conn.RemoteAddr()
+}
+
+/*
+代理的地址，也就是原始 socket 的地址
+*/
+func (s *Conn) ProxyAddr() net.Addr {
+	return s.Conn.RemoteAddr()
+}
+
+/*
+解析头部错误
+*/
+func (s *Conn) HeaderErr() error {
+	s.once.Do(s.readHeader)
+	return s.err
+}
+
+func (s *Conn) readHeader() {
+
+	if s.timeout > 0 {
+		s.Conn.SetReadDeadline(time.Now().Add(s.timeout))
+		defer s.Conn.SetReadDeadline(time.Time{})
+	}
+
+	s.srcAddr, s.err = ReadHeader(s.reader)
+}
+
+/*
+读取 PROXY 头部，返回客户端地址
+没有头部返回错误，开启 PROXY 协议的监听必须有头部
+*/
+func ReadHeader(r *bufio.Reader) (net.Addr, error) {
+
+	// v1 和 v2 至少需要 6 个字节判断
+	by, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, errors.New("读取 PROXY 头部失败 " + err.Error())
+	}
+
+	if bytes.Equal(by, v1Prefix) {
+		return readV1(r)
+	}
+
+	by, err = r.Peek(len(v2Sig))
+	if err != nil {
+		return nil, errors.New("读取 PROXY 头部失败 " + err.Error())
+	}
+
+	if bytes.Equal(by, v2Sig) {
+		return readV2(r)
+	}
+
+	return nil, errors.New("没有 PROXY 头部")
+}
+
+/*
+v1 文本头部
+PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+PROXY UNKNOWN\r\n
+*/
+func readV1(r *bufio.Reader) (net.Addr, error) {
+
+	line := make([]byte, 0, v1MaxLen)
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.New("读取 PROXY v1 头部失败 " + err.Error())
+		}
+
+		line = append(line, b)
+
+		if len(line) > v1MaxLen {
+			return nil, errors.New("PROXY v1 头部过长")
+		}
+
+		if b == '\n' {
+			break
+		}
+	}
+
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errors.New("PROXY v1 头部必须以 \\r\\n 结尾")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+
+	if len(fields) < 2 {
+		return nil, errors.New("PROXY v1 头部格式错误")
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		// 不知道客户端地址，使用代理地址
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, errors.New("PROXY v1 协议不支持 " + fields[1])
+	}
+
+	if len(fields) != 6 {
+		return nil, errors.New("PROXY v1 头部格式错误")
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, errors.New("PROXY v1 源地址错误 " + fields[2])
+	}
+
+	port, err := strconv.Atoi(fields[4])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, errors.New("PROXY v1 源端口错误 " + fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+/*
+v2 二进制头部
+12 字节签名 + 版本命令 1 + 地址族 1 + 长度 2 + 地址 + TLV
+*/
+func readV2(r *bufio.Reader) (net.Addr, error) {
+
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, errors.New("读取 PROXY v2 头部失败 " + err.Error())
+	}
+
+	verCmd := head[12]
+	if verCmd>>4 != 0x2 {
+		return nil, errors.New("PROXY v2 版本错误")
+	}
+
+	fam := head[13]
+	length := binary.BigEndian.Uint16(head[14:16])
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errors.New("读取 PROXY v2 地址失败 " + err.Error())
+	}
+
+	switch verCmd & 0x0F {
+	case 0x0:
+		// LOCAL 命令 健康检查等，使用代理地址
+		return nil, nil
+	case 0x1:
+		// PROXY 命令
+	default:
+		return nil, errors.New("PROXY v2 命令错误")
+	}
+
+	switch fam >> 4 {
+	case 0x1:
+		// IPv4 4 + 4 + 2 + 2
+		if len(data) < 12 {
+			return nil, errors.New("PROXY v2 IPv4 地址长度错误")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(data[0:4]),
+			Port: int(binary.BigEndian.Uint16(data[8:10])),
+		}, nil
+	case 0x2:
+		// IPv6 16 + 16 + 2 + 2
+		if len(data) < 36 {
+			return nil, errors.New("PROXY v2 IPv6 地址长度错误")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(data[0:16]),
+			Port: int(binary.BigEndian.Uint16(data[32:34])),
+		}, nil
+	default:
+		// UNSPEC，unix 等 使用代理地址
+		return nil, nil
+	}
+}
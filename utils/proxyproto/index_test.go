@@ -0,0 +1,109 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// v2 头部，addr 为地址部分
+func v2Header(cmd, fam byte, addr []byte) []byte {
+	by := append([]byte{}, v2Sig...)
+	by = append(by, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(by[14:16], uint16(len(addr)))
+	return append(by, addr...)
+}
+
+// v2 地址部分 源地址 目的地址 源端口 目的端口
+func v2Addr(src, dst net.IP, sport, dport uint16) []byte {
+	by := append(append([]byte{}, src...), dst...)
+	by = append(by, byte(sport>>8), byte(sport), byte(dport>>8), byte(dport))
+	return by
+}
+
+func TestReadHeader(t *testing.T) {
+
+	v4 := v2Addr(net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4(), 56324, 1883)
+	v6 := v2Addr(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 40000, 1883)
+
+	cases := []struct {
+		name    string
+		in      []byte
+		addr    string
+		wantErr bool
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 1883\r\n"), "192.168.0.1:56324", false},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 40000 1883\r\n"), "[2001:db8::1]:40000", false},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 没有 \\r", []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 1883\n"), "", true},
+		{"v1 端口错误", []byte("PROXY TCP4 192.168.0.1 192.168.0.11 70000 1883\r\n"), "", true},
+		{"v1 截断", []byte("PROXY TCP4 192.168.0.1"), "", true},
+		{"v2 PROXY IPv4", v2Header(0x1, 0x11, v4), "10.0.0.1:56324", false},
+		{"v2 PROXY IPv6", v2Header(0x1, 0x21, v6), "[2001:db8::1]:40000", false},
+		{"v2 LOCAL IPv4", v2Header(0x0, 0x11, v4), "", false},
+		{"v2 LOCAL IPv6", v2Header(0x0, 0x21, v6), "", false},
+		{"v2 地址截断", v2Header(0x1, 0x11, v4)[:20], "", true},
+		{"v2 头部截断", v2Sig[:10], "", true},
+		{"v2 IPv4 长度错误", v2Header(0x1, 0x11, v4[:8]), "", true},
+		{"v2 版本错误", append(append([]byte{}, v2Sig...), 0x11, 0x11, 0, 0), "", true},
+		{"签名错误", []byte{0x10, 0x2A, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x05, 0x02, 0x00, 0x3C}, "", true},
+	}
+
+	for _, c := range cases {
+		// 每次只读一个字节，头部分多次到达
+		for _, oneByte := range []bool{false, true} {
+			var r *bufio.Reader
+			if oneByte {
+				r = bufio.NewReader(iotest.OneByteReader(bytes.NewReader(c.in)))
+			} else {
+				r = bufio.NewReader(bytes.NewReader(c.in))
+			}
+
+			addr, err := ReadHeader(r)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("%s oneByte=%v: 错误 %v", c.name, oneByte, err)
+			}
+			if c.wantErr {
+				continue
+			}
+
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != c.addr {
+				t.Fatalf("%s oneByte=%v: 想要 %q 收到 %q", c.name, oneByte, c.addr, got)
+			}
+		}
+	}
+}
+
+func TestConnSplitHeader(t *testing.T) {
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	conn := NewConn(a, time.Second)
+
+	// 头部分三次写入，后面是 mqtt 数据
+	in := append(v2Header(0x1, 0x11, v2Addr(net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4(), 1234, 1883)), 0x10, 0x00)
+	go func() {
+		b.Write(in[:5])
+		b.Write(in[5:17])
+		b.Write(in[17:])
+	}()
+
+	if got := conn.RemoteAddr().String(); got != "10.0.0.1:1234" {
+		t.Fatalf("地址 %s", got)
+	}
+
+	buf := make([]byte, 2)
+	if n, err := conn.Read(buf); err != nil || n != 2 || buf[0] != 0x10 {
+		t.Fatalf("读取 %d % x %v", n, buf, err)
+	}
+}
@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+令牌桶限流
+每秒放入 rate 个令牌，桶最多 burst 个令牌，每次请求消耗一个令牌
+*/
+
+// 清理空闲桶的间隔
+const cleanInterval = time.Minute
+
+/*
+令牌桶，非并发安全，由 Limiter 加锁使用
+*/
+type Bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(rate float64, burst uint32, now time.Time) *Bucket {
+
+	b := bucketBurst(rate, burst)
+
+	return &Bucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		last:   now,
+	}
+}
+
+/*
+桶的容量，burst 为 0 时使用速率，至少可以通过一个
+*/
+func bucketBurst(rate float64, burst uint32) float64 {
+	b := float64(burst)
+	if b < 1 {
+		b = rate
+		if b < 1 {
+			b = 1
+		}
+	}
+	return b
+}
+
+/*
+补充令牌，有令牌时消耗一个返回 true
+*/
+func (s *Bucket) allow(now time.Time) bool {
+
+	s.refill(now)
+
+	if s.tokens < 1 {
+		return false
+	}
+
+	s.tokens--
+	return true
+}
+
+func (s *Bucket) refill(now time.Time) {
+	if now.After(s.last) {
+		s.tokens += now.Sub(s.last).Seconds() * s.rate
+		if s.tokens > s.burst {
+			s.tokens = s.burst
+		}
+	}
+	s.last = now
+}
+
+/*
+按 key 限流，每个 key 一个令牌桶，key 为空使用同一个桶
+rate 为 0 不限流
+*/
+type Limiter struct {
+	lock sync.Mutex
+
+	rate  float64
+	burst uint32
+
+	buckets   map[string]*Bucket
+	lastClean time.Time
+
+	// 当前时间，测试时替换
+	now func() time.Time
+}
+
+func NewLimiter(rate float64, burst uint32) *Limiter {
+	return &Limiter{
+		rate:      rate,
+		burst:     burst,
+		buckets:   make(map[string]*Bucket),
+		lastClean: time.Now(),
+		now:       time.Now,
+	}
+}
+
+/*
+是否允许通过
+*/
+func (s *Limiter) Allow(key string) bool {
+
+	now := s.now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.rate <= 0 {
+		return true
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = NewBucket(s.rate, s.burst, now)
+		s.buckets[key] = b
+	}
+
+	allow := b.allow(now)
+
+	if now.Sub(s.lastClean) > cleanInterval {
+		s.clean(now)
+	}
+
+	return allow
+}
+
+/*
+修改速率和突发数，已有的桶按原来的速率补充到现在后使用新的速率，令牌不超过新的容量
+没有修改时不操作，速率改为 0 时删除所有的桶
+*/
+func (s *Limiter) SetRate(rate float64, burst uint32) {
+
+	now := s.now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if rate == s.rate && burst == s.burst {
+		return
+	}
+
+	s.rate = rate
+	s.burst = burst
+
+	if rate <= 0 {
+		s.buckets = make(map[string]*Bucket)
+		return
+	}
+
+	b := bucketBurst(rate, burst)
+	for _, bk := range s.buckets {
+		bk.refill(now)
+		bk.rate = rate
+		bk.burst = b
+		if bk.tokens > b {
+			bk.tokens = b
+		}
+	}
+}
+
+/*
+删除已经补满的桶，补满的桶和新建的桶一样
+*/
+func (s *Limiter) clean(now time.Time) {
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= b.burst {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastClean = now
+}
+
+/*
+当前记录的 key 数量
+*/
+func (s *Limiter) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.buckets)
+}
@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// 测试使用的时钟，只在调用 add 时前进
+type testClock struct {
+	t time.Time
+}
+
+func (s *testClock) now() time.Time {
+	return s.t
+}
+
+func (s *testClock) add(d time.Duration) {
+	s.t = s.t.Add(d)
+}
+
+func newTestLimiter(rate float64, burst uint32) (*Limiter, *testClock) {
+	clock := &testClock{t: time.Unix(1000, 0)}
+	l := NewLimiter(rate, burst)
+	l.now = clock.now
+	l.lastClean = clock.t
+	return l, clock
+}
+
+func TestLimiter(t *testing.T) {
+
+	type step struct {
+		wait  time.Duration
+		key   string
+		allow bool
+	}
+
+	cases := []struct {
+		name  string
+		rate  float64
+		burst uint32
+		steps []step
+	}{
+		{"burst 用完后拒绝", 1, 3, []step{
+			{0, "a", true}, {0, "a", true}, {0, "a", true}, {0, "a", false},
+		}},
+		{"按速率补充", 2, 1, []step{
+			{0, "a", true}, {0, "a", false},
+			{250 * time.Millisecond, "a", false},
+			{250 * time.Millisecond, "a", true}, {0, "a", false},
+		}},
+		{"补充不超过 burst", 10, 2, []step{
+			{0, "a", true}, {0, "a", true},
+			{time.Hour, "a", true}, {0, "a", true}, {0, "a", false},
+		}},
+		{"每个 key 一个桶", 1, 1, []step{
+			{0, "a", true}, {0, "a", false}, {0, "b", true}, {0, "", true}, {0, "", false},
+		}},
+		{"burst 为 0 使用速率", 3, 0, []step{
+			{0, "a", true}, {0, "a", true}, {0, "a", true}, {0, "a", false},
+		}},
+		{"速率小于 1 burst 为 0 至少通过一个", 0.5, 0, []step{
+			{0, "a", true}, {0, "a", false},
+			{time.Second, "a", false}, {time.Second, "a", true},
+		}},
+		{"速率为 0 不限流", 0, 0, []step{
+			{0, "a", true}, {0, "a", true}, {0, "a", true},
+		}},
+	}
+
+	for _, c := range cases {
+		l, clock := newTestLimiter(c.rate, c.burst)
+		for i, st := range c.steps {
+			clock.add(st.wait)
+			if got := l.Allow(st.key); got != st.allow {
+				t.Fatalf("%s 第 %d 步: 想要 %v 收到 %v", c.name, i, st.allow, got)
+			}
+		}
+	}
+}
+
+func TestLimiterClean(t *testing.T) {
+
+	l, clock := newTestLimiter(1, 2)
+
+	l.Allow("a")
+	l.Allow("b")
+	l.Allow("b")
+	if l.Len() != 2 {
+		t.Fatalf("桶数量 %d", l.Len())
+	}
+
+	// 超过清理间隔，a b 都已经补满，只留下新的 c
+	clock.add(cleanInterval + time.Second)
+	l.Allow("c")
+	if l.Len() != 1 {
+		t.Fatalf("桶数量 %d", l.Len())
+	}
+
+	// 速率为 0 不创建桶
+	z, _ := newTestLimiter(0, 5)
+	z.Allow("a")
+	if z.Len() != 0 {
+		t.Fatalf("桶数量 %d", z.Len())
+	}
+}
+
+func TestLimiterSetRate(t *testing.T) {
+
+	l, clock := newTestLimiter(1, 2)
+	l.Allow("a")
+	l.Allow("a")
+
+	// 没有修改时保留桶，用完令牌的 key 仍然拒绝
+	l.SetRate(1, 2)
+	if l.Allow("a") {
+		t.Fatal("没有修改不应该补满")
+	}
+
+	// 修改后按新的速率补充，新的 key 使用新的 burst
+	l.SetRate(4, 3)
+	clock.add(250 * time.Millisecond)
+	if !l.Allow("a") || l.Allow("a") {
+		t.Fatal("应该按新的速率补充一个")
+	}
+	for i, want := range []bool{true, true, true, false} {
+		if got := l.Allow("b"); got != want {
+			t.Fatalf("新的 key 第 %d 次: 想要 %v 收到 %v", i, want, got)
+		}
+	}
+
+	// 容量变小时令牌不超过新的容量
+	clock.add(time.Hour)
+	l.SetRate(4, 1)
+	if !l.Allow("a") || l.Allow("a") {
+		t.Fatal("令牌应该不超过新的 burst")
+	}
+
+	// 速率改为 0 不限流，删除所有的桶
+	l.SetRate(0, 0)
+	if !l.Allow("a") || l.Len() != 0 {
+		t.Fatalf("不限流 桶数量 %d", l.Len())
+	}
+}
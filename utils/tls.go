@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+/*
+TLS 监听配置
+ClientCAFile 不为空时开启双向认证，客户端必须提供由该CA签发的证书
+*/
+type TLSConfig struct {
+	// 是否开启 TLS 监听
+	Enable bool
+	// TLS 监听端口 默认 8883
+	Port uint16
+
+	// 服务端证书和私钥 pem 文件
+	CertFile string
+	KeyFile  string
+
+	// 客户端CA证书 pem 文件，设置后开启双向认证
+	ClientCAFile string
+
+	// 客户端证书作为 mqtt 身份(clientID)  "" 不使用, "cn" 使用CommonName, "san" 使用第一个 SAN
+	IdentityFrom string
+}
+
+// 证书身份来源
+const (
+	IdentityFromNone = ""
+	IdentityFromCN   = "cn"
+	IdentityFromSAN  = "san"
+)
+
+/*
+根据配置生成 tls.Config
+*/
+func (s *TLSConfig) NewTLSConfig() (*tls.Config, error) {
+
+	if s.CertFile == "" || s.KeyFile == "" {
+		return nil, errors.New("TLS 需要设置 CertFile 和 KeyFile")
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, errors.New("加载 TLS 证书失败 " + err.Error())
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if s.ClientCAFile != "" {
+		// 双向认证
+		pem, err := ioutil.ReadFile(s.ClientCAFile)
+		if err != nil {
+			return nil, errors.New("读取客户端CA失败 " + err.Error())
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("客户端CA证书格式错误")
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+/*
+获取证书中的身份
+*/
+func CertIdentity(cert *x509.Certificate, from string) string {
+
+	if cert == nil {
+		return ""
+	}
+
+	switch from {
+	case IdentityFromCN:
+		return cert.Subject.CommonName
+	case IdentityFromSAN:
+		sans := CertSANs(cert)
+		if len(sans) > 0 {
+			return sans[0]
+		}
+	}
+
+	return ""
+}
+
+/*
+证书中的 SAN 列表 DNS，Email，URI，IP 顺序
+*/
+func CertSANs(cert *x509.Certificate) []string {
+
+	if cert == nil {
+		return []string{}
+	}
+
+	list := make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.URIs)+len(cert.IPAddresses))
+
+	list = append(list, cert.DNSNames...)
+	list = append(list, cert.EmailAddresses...)
+
+	for _, u := range cert.URIs {
+		list = append(list, u.String())
+	}
+
+	for _, ip := range cert.IPAddresses {
+		list = append(list, ip.String())
+	}
+
+	return list
+}
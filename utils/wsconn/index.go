@@ -0,0 +1,149 @@
+package wsconn
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+mqtt over websocket
+把 websocket 链接封装成 net.Conn，mqtt 数据包使用二进制帧传输
+读取时多个帧按字节流拼接，和 tcp 的读取方式相同
+*/
+
+// mqtt 使用的子协议
+const SubProtocol = "mqtt"
+
+type Conn struct {
+	ws *websocket.Conn
+
+	// 当前正在读取的帧
+	reader io.Reader
+
+	// 写锁，websocket 不支持并发写
+	writeLock sync.Mutex
+
+	// wss 链接的 tls 信息
+	tlsState *tls.ConnectionState
+}
+
+func newConn(ws *websocket.Conn, state *tls.ConnectionState) *Conn {
+	return &Conn{
+		ws:       ws,
+		tlsState: state,
+	}
+}
+
+/*
+创建升级器，只接受 mqtt 子协议
+*/
+func NewUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		Subprotocols:    []string{SubProtocol},
+		// 浏览器跨域访问
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+}
+
+/*
+http 请求升级为 websocket 链接
+*/
+func Upgrade(up *websocket.Upgrader, w http.ResponseWriter, r *http.Request) (*Conn, error) {
+
+	ws, err := up.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if ws.Subprotocol() != SubProtocol {
+		ws.Close()
+		return nil, errors.New("websocket 子协议必须是 mqtt")
+	}
+
+	return newConn(ws, r.TLS), nil
+}
+
+/*
+wss 链接的 tls 信息，ws 链接返回 nil
+*/
+func (s *Conn) TLSState() *tls.ConnectionState {
+	return s.tlsState
+}
+
+func (s *Conn) Read(b []byte) (int, error) {
+
+	for {
+		if s.reader == nil {
+			mt, r, err := s.ws.NextReader()
+			if err != nil {
+				return 0, err
+			}
+
+			if mt != websocket.BinaryMessage {
+				return 0, errors.New("mqtt 只能使用二进制帧")
+			}
+
+			s.reader = r
+		}
+
+		n, err := s.reader.Read(b)
+		if err == io.EOF {
+			// 当前帧读取完成，读取下一帧
+			s.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (s *Conn) Write(b []byte) (int, error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if err := s.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (s *Conn) Close() error {
+	return s.ws.Close()
+}
+
+func (s *Conn) LocalAddr() net.Addr {
+	return s.ws.LocalAddr()
+}
+
+func (s *Conn) RemoteAddr() net.Addr {
+	return s.ws.RemoteAddr()
+}
+
+func (s *Conn) SetDeadline(t time.Time) error {
+	if err := s.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return s.ws.SetWriteDeadline(t)
+}
+
+func (s *Conn) SetReadDeadline(t time.Time) error {
+	return s.ws.SetReadDeadline(t)
+}
+
+func (s *Conn) SetWriteDeadline(t time.Time) error {
+	return s.ws.SetWriteDeadline(t)
+}
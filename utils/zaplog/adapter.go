@@ -0,0 +1,52 @@
+package zaplog
+
+import (
+	"github.com/guihai/ghmqtt/utils/ghlog"
+	"go.uber.org/zap"
+)
+
+/*
+zap 实现 ghlog.Logger，服务默认使用的日志
+*/
+type zapAdapter struct {
+	logger *zap.Logger
+}
+
+/*
+包装 zap 日志，行号显示调用日志接口的位置
+*/
+func NewAdapter(logger *zap.Logger) ghlog.Logger {
+	return &zapAdapter{
+		logger: logger.WithOptions(zap.AddCallerSkip(1)),
+	}
+}
+
+func (s *zapAdapter) Debug(msg string, fields ...ghlog.Field) {
+	s.logger.Debug(msg, zapFields(fields)...)
+}
+
+func (s *zapAdapter) Info(msg string, fields ...ghlog.Field) {
+	s.logger.Info(msg, zapFields(fields)...)
+}
+
+func (s *zapAdapter) Warn(msg string, fields ...ghlog.Field) {
+	s.logger.Warn(msg, zapFields(fields)...)
+}
+
+func (s *zapAdapter) Error(msg string, fields ...ghlog.Field) {
+	s.logger.Error(msg, zapFields(fields)...)
+}
+
+func (s *zapAdapter) With(fields ...ghlog.Field) ghlog.Logger {
+	return &zapAdapter{
+		logger: s.logger.With(zapFields(fields)...),
+	}
+}
+
+func zapFields(fields []ghlog.Field) []zap.Field {
+	zf := make([]zap.Field, len(fields))
+	for i, f := range fields {
+		zf[i] = zap.Any(f.Key, f.Value)
+	}
+	return zf
+}
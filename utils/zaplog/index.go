@@ -5,6 +5,7 @@ import (
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
 	"os"
+	"strconv"
 )
 
 type LogConfig struct {
@@ -16,15 +17,52 @@ type LogConfig struct {
 	Compress bool `json:"Compress" yaml:"Compress"`
 
 	// 日志级别
-	Level zapcore.Level `json:"Level" yaml:"Level"` // - 1 =》 5
+	Level Level `json:"Level" yaml:"Level"` // - 1 =》 5
 
 	//是否空值台输出
 	StdoutFLag bool `json:"StdoutFLag" yaml:"StdoutFLag"`
 }
 
-var ZapLogger *zap.Logger
+/*
+日志级别，配置中可以使用数字 -1 到 5，或者名称 debug, info, warn, error, dpanic, panic, fatal
+*/
+type Level int8
 
-func InitLogger(cfg *LogConfig) {
+func (l *Level) UnmarshalText(text []byte) error {
+	if n, err := strconv.ParseInt(string(text), 10, 8); err == nil {
+		*l = Level(n)
+		return nil
+	}
+
+	var zl zapcore.Level
+	if err := zl.UnmarshalText(text); err != nil {
+		return err
+	}
+	*l = Level(zl)
+	return nil
+}
+
+func (l *Level) UnmarshalJSON(data []byte) error {
+	s, err := strconv.Unquote(string(data))
+	if err != nil {
+		// 数字
+		s = string(data)
+	}
+	return l.UnmarshalText([]byte(s))
+}
+
+/*
+根据配置创建日志，文件在第一次写日志时创建
+*/
+func NewLogger(cfg *LogConfig) *zap.Logger {
+	logger, _ := NewLoggerLevel(cfg)
+	return logger
+}
+
+/*
+根据配置创建日志，同时返回日志级别，修改级别后立即生效
+*/
+func NewLoggerLevel(cfg *LogConfig) (*zap.Logger, zap.AtomicLevel) {
 	hook := lumberjack.Logger{
 		Filename:   cfg.Filename,   // 日志文件路径
 		MaxSize:    cfg.MaxSize,    // 每个日志文件保存的最大尺寸 单位：M
@@ -51,7 +89,7 @@ func InitLogger(cfg *LogConfig) {
 	// 设置日志级别
 	atomicLevel := zap.NewAtomicLevel()
 	//atomicLevel.SetLevel(zap.InfoLevel)
-	atomicLevel.SetLevel(cfg.Level)
+	atomicLevel.SetLevel(zapcore.Level(cfg.Level))
 
 	var wType zapcore.WriteSyncer
 	// 设置输出类型
@@ -76,12 +114,9 @@ func InitLogger(cfg *LogConfig) {
 	// 设置初始化字段
 	//filed := zap.Fields(zap.String("serviceName", "serviceName"))
 	// 构造日志
-	ZapLogger = zap.New(core, caller, development)
-
-	ZapLogger.Info("log 初始化成功")
 	//logger.Info("无法获取网址",
 	//	zap.String("url", "http://www.baidu.com"),
 	//	zap.Int("attempt", 3),
 	//	zap.Duration("backoff", time.Second))
-
+	return zap.New(core, caller, development), atomicLevel
 }